@@ -0,0 +1,59 @@
+package appstream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/julian3xl/terraform-provider-appstream/appstream/internal/arn"
+)
+
+// importStateWithARN imports resources by ARN, or by ID as handled by
+// importStateWithRegion. The region of the ARN is set as the resource region.
+func importStateWithARN(resourceType string) schema.StateContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+		if !arn.IsARN(d.Id()) {
+			return importStateWithRegion(ctx, d, meta)
+		}
+
+		parsed, err := arn.Parse(d.Id())
+		if err != nil {
+			return nil, fmt.Errorf("error parsing import ID (%s): %w", d.Id(), err)
+		}
+
+		if parsed.ResourceType != resourceType {
+			return nil, fmt.Errorf("error importing ARN (%s): expected a %s ARN", d.Id(), resourceType)
+		}
+
+		if client := meta.(*AWSClient); client.accountid != "" && parsed.AccountID != client.accountid {
+			return nil, fmt.Errorf("error importing ARN (%s): account ID does not match the provider account ID (%s)", d.Id(), client.accountid)
+		}
+
+		d.Set("region", parsed.Region)
+		d.SetId(parsed.Name)
+
+		return []*schema.ResourceData{d}, nil
+	}
+}
+
+// validateAppstreamARN checks that the value is an AppStream ARN of one of
+// the resource types.
+func validateAppstreamARN(resourceTypes ...string) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		parsed, err := arn.Parse(v.(string))
+		if err != nil {
+			errors = append(errors, fmt.Errorf("%q (%s) is not a valid Appstream ARN: %s", k, v.(string), err))
+			return
+		}
+
+		for _, resourceType := range resourceTypes {
+			if parsed.ResourceType == resourceType {
+				return
+			}
+		}
+
+		errors = append(errors, fmt.Errorf("%q (%s) must be an Appstream %s ARN", k, v.(string), strings.Join(resourceTypes, " or ")))
+		return
+	}
+}
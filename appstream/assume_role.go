@@ -0,0 +1,100 @@
+package appstream
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// AssumeRole holds one hop of the assume_role chain.
+type AssumeRole struct {
+	ARN               string
+	DurationSeconds   int
+	ExternalID        string
+	Policy            string
+	PolicyARNs        []string
+	SessionName       string
+	Tags              map[string]string
+	TransitiveTagKeys []string
+}
+
+// assumeRoleChain assumes the roles of the chain in order, each one with the
+// credentials of the previous hop, starting from the session credentials.
+// It returns a session using the credentials of the last role, along with
+// the account ID and partition parsed from its ARN.
+func assumeRoleChain(sess *session.Session, chain []AssumeRole, stsEndpoint string) (*session.Session, string, string, error) {
+	var accountID, partition string
+
+	for i, assumeRole := range chain {
+		hop := i + 1
+
+		log.Printf("[INFO] Attempting to AssumeRole %s (hop %d, SessionName: %q, ExternalId: %q)",
+			assumeRole.ARN, hop, assumeRole.SessionName, assumeRole.ExternalID)
+
+		creds := credentials.NewCredentials(assumeRole.provider(sts.New(sess, &aws.Config{Endpoint: aws.String(stsEndpoint)})))
+		if _, err := creds.Get(); err != nil {
+			log.Printf("[ERROR] Error assuming role %s (hop %d): %s", assumeRole.ARN, hop, err)
+			return nil, "", "", fmt.Errorf("error assuming IAM Role (%s) in assume_role hop %d: %w", assumeRole.ARN, hop, err)
+		}
+
+		roleARN, err := arn.Parse(assumeRole.ARN)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("error parsing IAM Role ARN (%s) in assume_role hop %d: %w", assumeRole.ARN, hop, err)
+		}
+
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+		accountID, partition = roleARN.AccountID, roleARN.Partition
+	}
+
+	return sess, accountID, partition, nil
+}
+
+// provider returns an STS AssumeRole credentials provider for the hop.
+func (r AssumeRole) provider(conn *sts.STS) *stscreds.AssumeRoleProvider {
+	provider := &stscreds.AssumeRoleProvider{
+		Client:  conn,
+		RoleARN: r.ARN,
+	}
+
+	if r.DurationSeconds > 0 {
+		provider.Duration = time.Duration(r.DurationSeconds) * time.Second
+	}
+
+	if r.ExternalID != "" {
+		provider.ExternalID = aws.String(r.ExternalID)
+	}
+
+	if r.Policy != "" {
+		provider.Policy = aws.String(r.Policy)
+	}
+
+	for _, policyARN := range r.PolicyARNs {
+		provider.PolicyArns = append(provider.PolicyArns, &sts.PolicyDescriptorType{
+			Arn: aws.String(policyARN),
+		})
+	}
+
+	if r.SessionName != "" {
+		provider.RoleSessionName = r.SessionName
+	}
+
+	for k, v := range r.Tags {
+		provider.Tags = append(provider.Tags, &sts.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+
+	if len(r.TransitiveTagKeys) > 0 {
+		provider.TransitiveTagKeys = aws.StringSlice(r.TransitiveTagKeys)
+	}
+
+	return provider
+}
@@ -1,15 +1,28 @@
 package appstream
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/appstream"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/imagebuilder"
+	"github.com/aws/aws-sdk-go/service/sts"
 	awsbase "github.com/hashicorp/aws-sdk-go-base"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/logging"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/logging"
 )
 
 type Config struct {
@@ -20,19 +33,31 @@ type Config struct {
 	Token         string
 	Region        string
 	MaxRetries    int
+	RetryMode     string
+	APIRateLimit  float64
+	APIBurst      int
 
-	AssumeRoleARN         string
-	AssumeRoleExternalID  string
-	AssumeRoleSessionName string
-	AssumeRolePolicy      string
+	// AssumeRoles is the assume_role chain, assumed in order.
+	AssumeRoles []AssumeRole
+
+	AssumeRoleWithWebIdentityARN         string
+	AssumeRoleWithWebIdentitySessionName string
+	AssumeRoleWithWebIdentityTokenFile   string
 
 	AllowedAccountIds   []string
 	ForbiddenAccountIds []string
 
+	DefaultTags       map[string]string
 	Endpoints         map[string]string
 	IgnoreTagPrefixes []string
 	IgnoreTags        []string
-	Insecure          bool
+
+	CustomCABundle string
+	HTTPProxy      string
+	Insecure       bool
+
+	UseDualStackEndpoint bool
+	UseFIPSEndpoint      bool
 
 	SkipCredsValidation     bool
 	SkipGetEC2Platforms     bool
@@ -47,12 +72,25 @@ type Config struct {
 type AWSClient struct {
 	accountid          string
 	appstreamconn      *appstream.AppStream
+	config             *Config
+	defaultTagsConfig  *defaultTagsConfig
 	dnsSuffix          string
+	ignoreTagsConfig   *ignoreTagsConfig
 	imagebuilderconn   *imagebuilder.Imagebuilder
 	partition          string
 	region             string
+	session            *session.Session
+	stsconn            *sts.STS
 	supportedplatforms []string
 	terraformVersion   string
+
+	useDualStackEndpoint bool
+	useFIPSEndpoint      bool
+
+	// regionalappstreamconns caches the AppStream clients of the regions
+	// set through the resource region attribute.
+	regionalappstreamconns     map[string]*appstream.AppStream
+	regionalappstreamconnsLock sync.Mutex
 }
 
 // PartitionHostname returns a hostname with the provider domain suffix for the partition
@@ -63,10 +101,48 @@ func (client *AWSClient) PartitionHostname(prefix string) string {
 }
 
 // RegionalHostname returns a hostname with the provider domain suffix for the region and partition
-// e.g. PREFIX.us-west-2.amazonaws.com
+// e.g. PREFIX.us-west-2.amazonaws.com, or PREFIX-fips.us-west-2.api.aws with
+// use_fips_endpoint and use_dualstack_endpoint enabled.
 // The prefix should not contain a trailing period.
 func (client *AWSClient) RegionalHostname(prefix string) string {
-	return fmt.Sprintf("%s.%s.%s", prefix, client.region, client.dnsSuffix)
+	dnsSuffix := client.dnsSuffix
+	if client.useDualStackEndpoint {
+		dnsSuffix = regionDNSSuffix(client.region, true)
+	}
+
+	return endpointHostname(prefix, client.region, dnsSuffix, client.useFIPSEndpoint)
+}
+
+// AppstreamConnForRegion returns the AppStream client for the region, building
+// and caching it on first use. An empty region or the provider region returns
+// the provider client.
+func (client *AWSClient) AppstreamConnForRegion(region string) *appstream.AppStream {
+	if region == "" || region == client.region {
+		return client.appstreamconn
+	}
+
+	client.regionalappstreamconnsLock.Lock()
+	defer client.regionalappstreamconnsLock.Unlock()
+
+	if conn, ok := client.regionalappstreamconns[region]; ok {
+		return conn
+	}
+
+	if client.regionalappstreamconns == nil {
+		client.regionalappstreamconns = make(map[string]*appstream.AppStream)
+	}
+
+	// The appstream endpoint override targets the provider region, the
+	// clients of other regions use the default endpoints.
+	if client.config.Endpoints["appstream"] != "" {
+		log.Printf("[WARN] Ignoring the appstream endpoint override for region %s", region)
+	}
+
+	log.Printf("[INFO] Building AppStream client for region %s", region)
+	conn := client.config.appstreamConn(client.session.Copy(&aws.Config{Region: aws.String(region)}), "")
+	client.regionalappstreamconns[region] = conn
+
+	return conn
 }
 
 // Client configures and returns a fully initialized AWSClient
@@ -79,26 +155,52 @@ func (c *Config) Client() (interface{}, error) {
 		}
 	}
 
+	httpClient, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	stsEndpoint := c.Endpoints["sts"]
+	if stsEndpoint == "" && (c.UseFIPSEndpoint || c.UseDualStackEndpoint) {
+		stsEndpoint = "https://" + endpointHostname("sts", c.Region, regionDNSSuffix(c.Region, c.UseDualStackEndpoint), c.UseFIPSEndpoint)
+	}
+
+	// Web identity credentials are passed to aws-sdk-go-base as static
+	// credentials, which take precedence over the environment and the shared
+	// credentials file, then swapped for the refreshing provider.
+	accessKey, secretKey, token := c.AccessKey, c.SecretKey, c.Token
+	var webIdentityCreds *credentials.Credentials
+	if c.AssumeRoleWithWebIdentityARN != "" {
+		log.Printf("[INFO] Using web identity token file %q to assume role %q", c.AssumeRoleWithWebIdentityTokenFile, c.AssumeRoleWithWebIdentityARN)
+		webIdentityCreds = c.webIdentityCredentials(httpClient, stsEndpoint)
+
+		value, err := webIdentityCreds.Get()
+		if err != nil {
+			return nil, fmt.Errorf("error assuming IAM Role (%s) with web identity token file (%s): %w", c.AssumeRoleWithWebIdentityARN, c.AssumeRoleWithWebIdentityTokenFile, err)
+		}
+
+		if c.AccessKey != "" || c.Profile != "" {
+			log.Printf("[WARN] assume_role_with_web_identity takes precedence over access_key and profile")
+		}
+
+		accessKey, secretKey, token = value.AccessKeyID, value.SecretAccessKey, value.SessionToken
+	}
+
 	log.Println("[INFO] Building AWS auth structure")
 	awsbaseConfig := &awsbase.Config{
-		AccessKey:               c.AccessKey,
-		AssumeRoleARN:           c.AssumeRoleARN,
-		AssumeRoleExternalID:    c.AssumeRoleExternalID,
-		AssumeRolePolicy:        c.AssumeRolePolicy,
-		AssumeRoleSessionName:   c.AssumeRoleSessionName,
-		CredsFilename:           c.CredsFilename,
-		DebugLogging:            logging.IsDebugOrHigher(),
-		IamEndpoint:             c.Endpoints["iam"],
-		Insecure:                c.Insecure,
-		MaxRetries:              c.MaxRetries,
-		Profile:                 c.Profile,
-		Region:                  c.Region,
-		SecretKey:               c.SecretKey,
-		SkipCredsValidation:     c.SkipCredsValidation,
-		SkipMetadataApiCheck:    c.SkipMetadataApiCheck,
-		SkipRequestingAccountId: c.SkipRequestingAccountId,
-		StsEndpoint:             c.Endpoints["sts"],
-		Token:                   c.Token,
+		AccessKey:            accessKey,
+		CredsFilename:        c.CredsFilename,
+		DebugLogging:         logging.IsDebugOrHigher(),
+		IamEndpoint:          c.Endpoints["iam"],
+		Insecure:             c.Insecure,
+		MaxRetries:           c.MaxRetries,
+		Profile:              c.Profile,
+		Region:               c.Region,
+		SecretKey:            secretKey,
+		SkipCredsValidation:  true,
+		SkipMetadataApiCheck: c.SkipMetadataApiCheck,
+		StsEndpoint:          stsEndpoint,
+		Token:                token,
 		UserAgentProducts: []*awsbase.UserAgentProduct{
 			{Name: "APN", Version: "1.0"},
 			{Name: "HashiCorp", Version: "1.0"},
@@ -107,28 +209,181 @@ func (c *Config) Client() (interface{}, error) {
 		},
 	}
 
-	sess, accountID, partition, err := awsbase.GetSessionWithAccountIDAndPartition(awsbaseConfig)
+	// aws-sdk-go-base only resolves the credentials: its STS and IAM calls
+	// would not go through httpClient, so the assume_role chain, credentials
+	// validation and account ID lookup are done once the session is built.
+	sess, err := awsbase.GetSession(awsbaseConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	sess = sess.Copy(&aws.Config{HTTPClient: httpClient})
+
+	if webIdentityCreds != nil {
+		sess = sess.Copy(&aws.Config{Credentials: webIdentityCreds})
+	}
+
+	sess, accountID, partition, err := assumeRoleChain(sess, c.AssumeRoles, stsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.AssumeRoles) == 0 {
+		accountID, partition, err = c.accountIDAndPartition(sess, stsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if accountID == "" {
 		log.Printf("[WARN] AWS account ID not found for provider. See https://www.terraform.io/docs/providers/aws/index.html#skip_requesting_account_id for implications.")
 	}
 
-	dnsSuffix := "amazonaws.com"
-	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), c.Region); ok {
-		dnsSuffix = p.DNSSuffix()
+	if len(c.AllowedAccountIds) > 0 || len(c.ForbiddenAccountIds) > 0 {
+		if accountID == "" {
+			return nil, fmt.Errorf("unable to check allowed_account_ids and forbidden_account_ids: AWS account ID could not be determined")
+		}
+
+		if err := awsbase.ValidateAccountID(accountID, c.AllowedAccountIds, c.ForbiddenAccountIds); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.APIRateLimit > 0 {
+		log.Printf("[INFO] Limiting AWS API calls to %g requests per second (burst %d)", c.APIRateLimit, c.APIBurst)
+		sess.Handlers.Sign.PushFrontNamed(newAPIRateLimiter(c.APIRateLimit, c.APIBurst).handler())
 	}
 
+	dnsSuffix := regionDNSSuffix(c.Region, false)
+
 	client := &AWSClient{
-		accountid:        accountID,
-		appstreamconn:    appstream.New(sess.Copy(&aws.Config{Endpoint: aws.String(c.Endpoints["appstream"])})),
-		dnsSuffix:        dnsSuffix,
-		imagebuilderconn: imagebuilder.New(sess.Copy(&aws.Config{Endpoint: aws.String(c.Endpoints["imagebuilder"])})),
-		partition:        partition,
-		region:           c.Region,
-		terraformVersion: c.terraformVersion,
+		accountid:         accountID,
+		appstreamconn:     c.appstreamConn(sess, c.Endpoints["appstream"]),
+		config:            c,
+		defaultTagsConfig: &defaultTagsConfig{tags: c.DefaultTags},
+		dnsSuffix:         dnsSuffix,
+		ignoreTagsConfig:  &ignoreTagsConfig{keys: c.IgnoreTags, keyPrefixes: c.IgnoreTagPrefixes},
+		imagebuilderconn:  imagebuilder.New(sess.Copy(&aws.Config{Endpoint: aws.String(c.Endpoints["imagebuilder"])})),
+		partition:         partition,
+		region:            c.Region,
+		session:           sess,
+		stsconn:           sts.New(sess.Copy(&aws.Config{Endpoint: aws.String(stsEndpoint)})),
+		terraformVersion:  c.terraformVersion,
+
+		useDualStackEndpoint: c.UseDualStackEndpoint,
+		useFIPSEndpoint:      c.UseFIPSEndpoint,
+	}
+	return client, nil
+}
+
+// httpClient returns the HTTP client used by every AWS client, honoring
+// the custom_ca_bundle, http_proxy and insecure provider arguments.
+func (c *Config) httpClient() (*http.Client, error) {
+	client := cleanhttp.DefaultClient()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.HTTPProxy != "" {
+		proxyURL, err := url.Parse(c.HTTPProxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing http_proxy (%s): %w", c.HTTPProxy, err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.CustomCABundle != "" {
+		bundle, err := ioutil.ReadFile(c.CustomCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading custom_ca_bundle (%s): %w", c.CustomCABundle, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("error loading custom_ca_bundle (%s): no PEM certificates found", c.CustomCABundle)
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
 	}
+
 	return client, nil
 }
+
+// accountIDAndPartition validates the session credentials and returns the
+// account ID and partition they belong to, honoring skip_credentials_validation
+// and skip_requesting_account_id.
+func (c *Config) accountIDAndPartition(sess *session.Session, stsEndpoint string) (string, string, error) {
+	stsconn := sts.New(sess.Copy(&aws.Config{Endpoint: aws.String(stsEndpoint)}))
+
+	if !c.SkipCredsValidation {
+		accountID, partition, err := awsbase.GetAccountIDAndPartitionFromSTSGetCallerIdentity(stsconn)
+		if err != nil {
+			return "", "", fmt.Errorf("error validating provider credentials: %w", err)
+		}
+
+		return accountID, partition, nil
+	}
+
+	if !c.SkipRequestingAccountId {
+		credentialsProviderName := ""
+		if value, err := sess.Config.Credentials.Get(); err == nil {
+			credentialsProviderName = value.ProviderName
+		}
+
+		iamconn := iam.New(sess.Copy(&aws.Config{Endpoint: aws.String(c.Endpoints["iam"])}))
+
+		accountID, partition, err := awsbase.GetAccountIDAndPartition(iamconn, stsconn, credentialsProviderName)
+		if err != nil {
+			return "", "", fmt.Errorf("AWS account ID not previously found and failed retrieving via all available methods. "+
+				"See https://www.terraform.io/docs/providers/aws/index.html#skip_requesting_account_id for workaround and implications. "+
+				"Errors: %w", err)
+		}
+
+		return accountID, partition, nil
+	}
+
+	var partition string
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), c.Region); ok {
+		partition = p.ID()
+	}
+
+	return "", partition, nil
+}
+
+// webIdentityCredentials returns credentials assuming the
+// assume_role_with_web_identity role with the token file, refreshed when
+// they expire. AssumeRoleWithWebIdentity calls are not signed.
+func (c *Config) webIdentityCredentials(httpClient *http.Client, stsEndpoint string) *credentials.Credentials {
+	conn := sts.New(session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.AnonymousCredentials,
+		Endpoint:    aws.String(stsEndpoint),
+		HTTPClient:  httpClient,
+		Region:      aws.String(c.Region),
+	})))
+
+	return credentials.NewCredentials(stscreds.NewWebIdentityRoleProvider(conn, c.AssumeRoleWithWebIdentityARN, c.AssumeRoleWithWebIdentitySessionName, c.AssumeRoleWithWebIdentityTokenFile))
+}
+
+// appstreamConn returns an AppStream client that retries throttling and
+// transient state transition errors according to the provider retry settings.
+func (c *Config) appstreamConn(sess *session.Session, endpoint string) *appstream.AppStream {
+	retryer := newAppstreamRetryer(c.MaxRetries, c.RetryMode)
+
+	// The SDK debug log dumps request bodies verbatim, which would include
+	// directory service account credentials; apiCallLogHandler replaces it.
+	conn := appstream.New(sess.Copy(request.WithRetryer(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		EndpointResolver: c.endpointResolver(),
+		LogLevel:         aws.LogLevel(aws.LogOff),
+	}, retryer)))
+
+	if retryer.throttle != nil {
+		conn.Handlers.Send.PushFront(retryer.throttle.wait)
+	}
+
+	conn.Handlers.Complete.PushBackNamed(apiCallLogHandler)
+
+	return conn
+}
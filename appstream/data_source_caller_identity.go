@@ -0,0 +1,71 @@
+package appstream
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceAppstreamCallerIdentity() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceAppstreamCallerIdentityRead,
+
+		Schema: map[string]*schema.Schema{
+			"account_id": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"arn": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"user_id": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"partition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"region": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"dns_suffix": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceAppstreamCallerIdentityRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*AWSClient)
+
+	resp, err := client.stsconn.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Printf("[ERROR] Error getting caller identity: %s", err)
+		return diag.FromErr(err)
+	}
+
+	log.Printf("[DEBUG] Received caller identity (account: %s, arn: %s)", aws.StringValue(resp.Account), aws.StringValue(resp.Arn))
+
+	d.SetId(aws.StringValue(resp.Account))
+	d.Set("account_id", resp.Account)
+	d.Set("arn", resp.Arn)
+	d.Set("user_id", resp.UserId)
+	d.Set("partition", client.partition)
+	d.Set("region", client.region)
+	d.Set("dns_suffix", client.dnsSuffix)
+
+	return nil
+}
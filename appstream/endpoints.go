@@ -0,0 +1,60 @@
+package appstream
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// dualStackDNSSuffixes maps partitions to the DNS suffix of their dual-stack
+// (IPv4 and IPv6) endpoints.
+var dualStackDNSSuffixes = map[string]string{
+	endpoints.AwsPartitionID:      "api.aws",
+	endpoints.AwsCnPartitionID:    "api.amazonwebservices.com.cn",
+	endpoints.AwsUsGovPartitionID: "api.aws",
+}
+
+// regionDNSSuffix returns the DNS suffix of the region partition, or of its
+// dual-stack endpoints.
+func regionDNSSuffix(region string, useDualStack bool) string {
+	partitionID, dnsSuffix := endpoints.AwsPartitionID, "amazonaws.com"
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partitionID, dnsSuffix = p.ID(), p.DNSSuffix()
+	}
+
+	if useDualStack {
+		if v, ok := dualStackDNSSuffixes[partitionID]; ok {
+			return v
+		}
+	}
+
+	return dnsSuffix
+}
+
+// endpointHostname returns the hostname of a regional endpoint
+// e.g. PREFIX.us-west-2.amazonaws.com or PREFIX-fips.us-gov-west-1.api.aws
+func endpointHostname(prefix, region, dnsSuffix string, useFIPS bool) string {
+	if useFIPS {
+		prefix += "-fips"
+	}
+
+	return fmt.Sprintf("%s.%s.%s", prefix, region, dnsSuffix)
+}
+
+// endpointResolver returns the default endpoint resolver, switched to FIPS
+// and dual-stack endpoints as configured with use_fips_endpoint and
+// use_dualstack_endpoint.
+func (c *Config) endpointResolver() endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		resolvedEndpoint, err := endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+		if err != nil {
+			return resolvedEndpoint, err
+		}
+
+		if c.UseFIPSEndpoint || c.UseDualStackEndpoint {
+			resolvedEndpoint.URL = "https://" + endpointHostname(service, region, regionDNSSuffix(region, c.UseDualStackEndpoint), c.UseFIPSEndpoint)
+		}
+
+		return resolvedEndpoint, nil
+	})
+}
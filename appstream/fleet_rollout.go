@@ -0,0 +1,437 @@
+package appstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/appstream"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	fleetImageRolloutInPlace   = "in_place"
+	fleetImageRolloutBlueGreen = "blue_green"
+
+	// fleetRolloutSuffix is appended to the configured name of the fleet
+	// created by a blue/green image rollout. Rollouts alternate between the
+	// configured name and the suffixed one.
+	fleetRolloutSuffix = "-green"
+
+	// fleetStateAvailable is the state reported while waiting for a fleet
+	// that is running with all of its desired capacity.
+	fleetStateAvailable = "AVAILABLE"
+)
+
+// fleetName returns the name of the AppStream fleet managed by the resource.
+// It is the ID of the resource until a blue/green image rollout replaces the
+// fleet. The planned fleet_name is unknown while a rollout is pending, see
+// fleetImageRolloutDiff.
+func fleetName(d *schema.ResourceData) string {
+	if v := d.Get("fleet_name").(string); v != "" {
+		return v
+	}
+
+	if o, _ := d.GetChange("fleet_name"); o.(string) != "" {
+		return o.(string)
+	}
+
+	return d.Id()
+}
+
+// fleetImageRolloutDiff marks the attributes a blue/green image rollout
+// changes as unknown, so that resources referencing the fleet are planned
+// against the new fleet.
+func fleetImageRolloutDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.HasChanges("image_arn", "image_name") || fleetImageRolloutStrategy(d) != fleetImageRolloutBlueGreen {
+		return nil
+	}
+
+	if o, n := d.GetChange("state"); o.(string) != appstream.FleetStateRunning || n.(string) != appstream.FleetStateRunning {
+		return nil
+	}
+
+	for _, k := range []string{"arn", "created_time", "fleet_name"} {
+		if err := d.SetNewComputed(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fleetImageRolloutStrategy returns the strategy of the image_rollout block.
+func fleetImageRolloutStrategy(d interface{ Get(string) interface{} }) string {
+	if l, ok := d.Get("image_rollout").([]interface{}); ok && len(l) > 0 && l[0] != nil {
+		return l[0].(map[string]interface{})["strategy"].(string)
+	}
+
+	return fleetImageRolloutInPlace
+}
+
+// fleetImageRolloutDrainTimeout returns the drain_timeout of the
+// image_rollout block, zero if replaced fleets are only deleted once drained.
+func fleetImageRolloutDrainTimeout(d *schema.ResourceData) time.Duration {
+	if l, ok := d.Get("image_rollout").([]interface{}); ok && len(l) > 0 && l[0] != nil {
+		return time.Duration(l[0].(map[string]interface{})["drain_timeout"].(int)) * time.Second
+	}
+
+	return 0
+}
+
+// fleetPendingDeletionDiff plans the deletion of the fleets a previous
+// rollout left behind, so that the next apply retries it.
+func fleetPendingDeletionDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if len(d.Get("pending_deletion_fleet_names").([]interface{})) == 0 {
+		return nil
+	}
+
+	return d.SetNew("pending_deletion_fleet_names", []string{})
+}
+
+// addFleetPendingDeletion records a fleet left behind by a rollout.
+func addFleetPendingDeletion(d *schema.ResourceData, name string) {
+	names := d.Get("pending_deletion_fleet_names").([]interface{})
+	d.Set("pending_deletion_fleet_names", append(names, name))
+}
+
+// deleteFleetsPendingDeletion deletes the fleets a previous rollout left
+// behind.
+func deleteFleetsPendingDeletion(ctx context.Context, d *schema.ResourceData, svc *appstream.AppStream) error {
+	o, _ := d.GetChange("pending_deletion_fleet_names")
+	for _, name := range o.([]interface{}) {
+		log.Printf("[INFO] Deleting Appstream Fleet (%s) left behind by an image rollout", name)
+
+		if err := retireFleet(ctx, svc, name.(string), fleetImageRolloutDrainTimeout(d), d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return fmt.Errorf("error deleting Appstream Fleet (%s) left behind by an image rollout: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// listFleetsPendingDeletion returns the fleets recorded by
+// addFleetPendingDeletion that still exist.
+func listFleetsPendingDeletion(ctx context.Context, d *schema.ResourceData, svc *appstream.AppStream) ([]string, error) {
+	var names []string
+
+	for _, name := range d.Get("pending_deletion_fleet_names").([]interface{}) {
+		fleet, err := describeFleet(ctx, svc, name.(string))
+		if err != nil {
+			return nil, err
+		}
+
+		if fleet != nil {
+			names = append(names, name.(string))
+		}
+	}
+
+	return names, nil
+}
+
+// resetFleetChanges sets the attributes changed by the plan back to their
+// prior values, for updates that failed without changing the fleet.
+func resetFleetChanges(d *schema.ResourceData) {
+	for k := range resourceAppstreamFleet().Schema {
+		if k != "pending_deletion_fleet_names" && d.HasChange(k) {
+			o, _ := d.GetChange(k)
+			d.Set(k, o)
+		}
+	}
+}
+
+// fleetRolloutName returns the name of the fleet replacing the current one.
+func fleetRolloutName(name, current string) string {
+	if current == name {
+		return name + fleetRolloutSuffix
+	}
+
+	return name
+}
+
+// rolloutFleetImage replaces a running fleet with a new fleet built from the
+// resource configuration. The new fleet is started and associated to the
+// stacks of the current fleet once it runs with its desired capacity, then
+// the current fleet is retired. The new fleet is deleted if it reports fleet
+// errors or cannot be associated. Fleets that cannot be deleted are recorded
+// in pending_deletion_fleet_names and deleted by the next apply.
+func rolloutFleetImage(ctx context.Context, d *schema.ResourceData, meta interface{}) (string, error) {
+	svc := resourceAppstreamConn(d, meta)
+	timeout := d.Timeout(schema.TimeoutUpdate)
+
+	oldName := fleetName(d)
+	input := expandFleetCreateInput(d, meta)
+	input.Name = aws.String(fleetRolloutName(d.Get("name").(string), oldName))
+	newName := aws.StringValue(input.Name)
+
+	log.Printf("[INFO] Rolling out Appstream Fleet (%s) image to Appstream Fleet (%s)", oldName, newName)
+
+	stacks, err := listFleetAssociatedStacks(ctx, svc, oldName)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := svc.CreateFleetWithContext(ctx, input); err != nil {
+		log.Printf("[ERROR] Error creating Appstream Fleet: %s", err)
+		return "", err
+	}
+
+	rollback := func(cause error) error {
+		log.Printf("[WARN] Rolling back Appstream Fleet (%s) image rollout: %s", oldName, cause)
+
+		// The rollback runs even when the apply was cancelled.
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		for _, stack := range stacks {
+			svc.DisassociateFleetWithContext(rollbackCtx, &appstream.DisassociateFleetInput{
+				FleetName: aws.String(newName),
+				StackName: aws.String(stack),
+			}, retryOnStateTransition)
+		}
+
+		if err := deleteFleet(rollbackCtx, svc, newName, timeout); err != nil {
+			addFleetPendingDeletion(d, newName)
+			return fmt.Errorf("%s; rollback failed, Appstream Fleet (%s) is deleted by the next apply: %s", cause, newName, err)
+		}
+
+		return cause
+	}
+
+	if _, err := svc.StartFleetWithContext(ctx, &appstream.StartFleetInput{
+		Name: aws.String(newName),
+	}, retryOnNotFound, retryOnStateTransition); err != nil {
+		log.Printf("[ERROR] Error starting Appstream Fleet: %s", err)
+		return "", rollback(err)
+	}
+
+	if err := waitForFleetCapacity(ctx, svc, newName, timeout); err != nil {
+		return "", rollback(err)
+	}
+
+	for _, stack := range stacks {
+		log.Printf("[DEBUG] Associating Appstream Fleet (%s) to Stack (%s)", newName, stack)
+
+		if _, err := svc.AssociateFleetWithContext(ctx, &appstream.AssociateFleetInput{
+			FleetName: aws.String(newName),
+			StackName: aws.String(stack),
+		}, retryOnStateTransition); err != nil {
+			log.Printf("[ERROR] Error associating Appstream Fleet to Stack: %s", err)
+			return "", rollback(err)
+		}
+	}
+
+	// The new fleet serves the stacks from now on, failures past this point
+	// leave the old fleet behind instead of rolling back.
+	if err := retireFleet(ctx, svc, oldName, fleetImageRolloutDrainTimeout(d), timeout); err != nil {
+		addFleetPendingDeletion(d, oldName)
+		return newName, fmt.Errorf("error deleting Appstream Fleet (%s), it is deleted by the next apply: %w", oldName, err)
+	}
+
+	return newName, nil
+}
+
+// listFleetAssociatedStacks returns the names of the stacks associated to
+// the fleet.
+func listFleetAssociatedStacks(ctx context.Context, svc *appstream.AppStream, name string) ([]string, error) {
+	var stacks []string
+
+	input := &appstream.ListAssociatedStacksInput{
+		FleetName: aws.String(name),
+	}
+
+	for {
+		resp, err := svc.ListAssociatedStacksWithContext(ctx, input)
+		if err != nil {
+			log.Printf("[ERROR] Error listing Appstream Fleet associated stacks: %s", err)
+			return nil, err
+		}
+
+		stacks = append(stacks, aws.StringValueSlice(resp.Names)...)
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return stacks, nil
+		}
+
+		input.NextToken = resp.NextToken
+	}
+}
+
+// waitForFleetCapacity waits for the fleet to run all of its desired
+// instances. It fails as soon as the fleet reports fleet errors or stops.
+func waitForFleetCapacity(ctx context.Context, svc *appstream.AppStream, name string, timeout time.Duration) error {
+	stateConf := &retry.StateChangeConf{
+		Pending:      []string{appstream.FleetStateStarting, appstream.FleetStateRunning},
+		Target:       []string{fleetStateAvailable},
+		Timeout:      timeout,
+		PollInterval: statePollInterval,
+		Refresh: func() (interface{}, string, error) {
+			fleet, err := describeFleet(ctx, svc, name)
+			if err != nil || fleet == nil {
+				return nil, "", err
+			}
+
+			if len(fleet.FleetErrors) > 0 {
+				return nil, "", fmt.Errorf("Appstream Fleet (%s) reported errors: %s", name, flattenFleetErrorMessages(fleet.FleetErrors))
+			}
+
+			state := aws.StringValue(fleet.State)
+			if state == appstream.FleetStateStopped {
+				return nil, "", fmt.Errorf("Appstream Fleet (%s) stopped before reaching its desired capacity", name)
+			}
+			if capacity := fleet.ComputeCapacityStatus; state == appstream.FleetStateRunning && capacity != nil && aws.Int64Value(capacity.Running) >= aws.Int64Value(capacity.Desired) {
+				state = fleetStateAvailable
+			}
+
+			return fleet, state, nil
+		},
+	}
+
+	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for Appstream Fleet (%s) capacity: %w", name, err)
+	}
+
+	return nil
+}
+
+// waitForFleetDrained waits for the user sessions of the fleet to end.
+func waitForFleetDrained(ctx context.Context, svc *appstream.AppStream, name string, timeout time.Duration) error {
+	stateConf := &retry.StateChangeConf{
+		Pending:      []string{"IN_USE"},
+		Target:       []string{"DRAINED"},
+		Timeout:      timeout,
+		PollInterval: statePollInterval,
+		Refresh: func() (interface{}, string, error) {
+			fleet, err := describeFleet(ctx, svc, name)
+			if err != nil || fleet == nil {
+				return nil, "", err
+			}
+
+			if capacity := fleet.ComputeCapacityStatus; capacity != nil && aws.Int64Value(capacity.InUse) > 0 {
+				log.Printf("[DEBUG] Appstream Fleet (%s) has %d instances in use", name, aws.Int64Value(capacity.InUse))
+				return fleet, "IN_USE", nil
+			}
+
+			return fleet, "DRAINED", nil
+		},
+	}
+
+	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for Appstream Fleet (%s) user sessions to end: %w", name, err)
+	}
+
+	return nil
+}
+
+// retireFleet disassociates the fleet from its stacks, waits for its user
+// sessions to end and deletes it. Sessions still open after drainTimeout are
+// ended by stopping the fleet, a zero drainTimeout waits for them until
+// timeout and fails.
+func retireFleet(ctx context.Context, svc *appstream.AppStream, name string, drainTimeout, timeout time.Duration) error {
+	fleet, err := describeFleet(ctx, svc, name)
+	if err != nil || fleet == nil {
+		return err
+	}
+
+	stacks, err := listFleetAssociatedStacks(ctx, svc, name)
+	if err != nil {
+		return err
+	}
+
+	for _, stack := range stacks {
+		log.Printf("[DEBUG] Disassociating Appstream Fleet (%s) from Stack (%s)", name, stack)
+
+		if _, err := svc.DisassociateFleetWithContext(ctx, &appstream.DisassociateFleetInput{
+			FleetName: aws.String(name),
+			StackName: aws.String(stack),
+		}, retryOnStateTransition); err != nil {
+			log.Printf("[ERROR] Error disassociating Appstream Fleet from Stack: %s", err)
+			return fmt.Errorf("error disassociating Appstream Fleet (%s) from Stack (%s): %w", name, stack, err)
+		}
+	}
+
+	if drainTimeout == 0 {
+		if err := waitForFleetDrained(ctx, svc, name, timeout); err != nil {
+			return err
+		}
+	} else if err := waitForFleetDrained(ctx, svc, name, drainTimeout); err != nil {
+		var timeoutErr *retry.TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			return err
+		}
+
+		log.Printf("[WARN] Stopping Appstream Fleet (%s) with user sessions still open", name)
+	}
+
+	return deleteFleet(ctx, svc, name, timeout)
+}
+
+// deleteFleet stops the fleet if needed and deletes it.
+func deleteFleet(ctx context.Context, svc *appstream.AppStream, name string, timeout time.Duration) error {
+	fleet, err := describeFleet(ctx, svc, name)
+	if err != nil || fleet == nil {
+		return err
+	}
+
+	if state := aws.StringValue(fleet.State); state != appstream.FleetStateStopped {
+		if state != appstream.FleetStateStopping {
+			if _, err := svc.StopFleetWithContext(ctx, &appstream.StopFleetInput{
+				Name: aws.String(name),
+			}, retryOnStateTransition); err != nil {
+				log.Printf("[ERROR] Error stopping Appstream Fleet: %s", err)
+				return err
+			}
+		}
+
+		if _, err := waitForFleetState(ctx, svc, name, appstream.FleetStateStopped, timeout); err != nil {
+			return err
+		}
+	}
+
+	_, err = svc.DeleteFleetWithContext(ctx, &appstream.DeleteFleetInput{
+		Name: aws.String(name),
+	}, retryOnStateTransition)
+
+	if err != nil {
+		log.Printf("[ERROR] Error deleting Appstream Fleet: %s", err)
+	}
+
+	return err
+}
+
+// describeFleet returns the fleet, or nil if it does not exist.
+func describeFleet(ctx context.Context, svc *appstream.AppStream, name string) (*appstream.Fleet, error) {
+	resp, err := svc.DescribeFleetsWithContext(ctx, &appstream.DescribeFleetsInput{
+		Names: aws.StringSlice([]string{name}),
+	})
+
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == appstream.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
+		return nil, err
+	}
+
+	if len(resp.Fleets) == 0 {
+		return nil, nil
+	}
+
+	return resp.Fleets[0], nil
+}
+
+func flattenFleetErrorMessages(fleetErrors []*appstream.FleetError) string {
+	messages := make([]string, 0, len(fleetErrors))
+	for _, fleetError := range fleetErrors {
+		messages = append(messages, fmt.Sprintf("%s: %s", aws.StringValue(fleetError.ErrorCode), aws.StringValue(fleetError.ErrorMessage)))
+	}
+
+	return strings.Join(messages, ", ")
+}
@@ -0,0 +1,93 @@
+// Package arn parses the ARNs of AppStream fleets, stacks, images
+// and image builders, e.g.
+// arn:aws:appstream:us-east-1:123456789012:fleet/NAME
+package arn
+
+import (
+	"fmt"
+	"strings"
+
+	awsarn "github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// Service is the service namespace of AppStream ARNs.
+const Service = "appstream"
+
+// Resource types of AppStream ARNs.
+const (
+	ResourceTypeFleet        = "fleet"
+	ResourceTypeImage        = "image"
+	ResourceTypeImageBuilder = "image-builder"
+	ResourceTypeStack        = "stack"
+)
+
+var resourceTypes = []string{
+	ResourceTypeFleet,
+	ResourceTypeImage,
+	ResourceTypeImageBuilder,
+	ResourceTypeStack,
+}
+
+// ARN is an AppStream resource ARN.
+type ARN struct {
+	Partition    string
+	Region       string
+	AccountID    string
+	ResourceType string
+	Name         string
+}
+
+// Parse parses an AppStream resource ARN. The account ID may only be empty
+// for images, as images shared by AWS have none.
+func Parse(s string) (ARN, error) {
+	parsed, err := awsarn.Parse(s)
+	if err != nil {
+		return ARN{}, err
+	}
+
+	if parsed.Service != Service {
+		return ARN{}, fmt.Errorf("arn: expected service %s, got %s", Service, parsed.Service)
+	}
+
+	if parsed.Region == "" {
+		return ARN{}, fmt.Errorf("arn: missing region")
+	}
+
+	i := strings.Index(parsed.Resource, "/")
+	if i < 0 || i == len(parsed.Resource)-1 {
+		return ARN{}, fmt.Errorf("arn: expected resource TYPE/NAME, got %s", parsed.Resource)
+	}
+
+	a := ARN{
+		Partition:    parsed.Partition,
+		Region:       parsed.Region,
+		AccountID:    parsed.AccountID,
+		ResourceType: parsed.Resource[:i],
+		Name:         parsed.Resource[i+1:],
+	}
+
+	if !isResourceType(a.ResourceType) {
+		return ARN{}, fmt.Errorf("arn: unknown resource type %s, expected one of %s", a.ResourceType, strings.Join(resourceTypes, ", "))
+	}
+
+	if a.AccountID == "" && a.ResourceType != ResourceTypeImage {
+		return ARN{}, fmt.Errorf("arn: missing account ID")
+	}
+
+	return a, nil
+}
+
+// IsARN reports whether the string looks like an ARN.
+func IsARN(s string) bool {
+	return awsarn.IsARN(s)
+}
+
+func isResourceType(resourceType string) bool {
+	for _, t := range resourceTypes {
+		if resourceType == t {
+			return true
+		}
+	}
+
+	return false
+}
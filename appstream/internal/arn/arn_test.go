@@ -0,0 +1,97 @@
+package arn
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    ARN
+		wantErr bool
+	}{
+		{
+			name:  "fleet",
+			input: "arn:aws:appstream:us-east-1:123456789012:fleet/example",
+			want: ARN{
+				Partition:    "aws",
+				Region:       "us-east-1",
+				AccountID:    "123456789012",
+				ResourceType: ResourceTypeFleet,
+				Name:         "example",
+			},
+		},
+		{
+			name:  "image builder",
+			input: "arn:aws-us-gov:appstream:us-gov-west-1:123456789012:image-builder/example",
+			want: ARN{
+				Partition:    "aws-us-gov",
+				Region:       "us-gov-west-1",
+				AccountID:    "123456789012",
+				ResourceType: ResourceTypeImageBuilder,
+				Name:         "example",
+			},
+		},
+		{
+			name:  "image shared by AWS",
+			input: "arn:aws:appstream:eu-west-1::image/AppStream-WinServer2019-06-12-2023",
+			want: ARN{
+				Partition:    "aws",
+				Region:       "eu-west-1",
+				ResourceType: ResourceTypeImage,
+				Name:         "AppStream-WinServer2019-06-12-2023",
+			},
+		},
+		{
+			name:    "fleet without account ID",
+			input:   "arn:aws:appstream:us-east-1::fleet/example",
+			wantErr: true,
+		},
+		{
+			name:    "missing region",
+			input:   "arn:aws:appstream::123456789012:stack/example",
+			wantErr: true,
+		},
+		{
+			name:    "unknown resource type",
+			input:   "arn:aws:appstream:us-east-1:123456789012:directory-config/example",
+			wantErr: true,
+		},
+		{
+			name:    "missing name",
+			input:   "arn:aws:appstream:us-east-1:123456789012:stack/",
+			wantErr: true,
+		},
+		{
+			name:    "other service",
+			input:   "arn:aws:ec2:us-east-1:123456789012:fleet/example",
+			wantErr: true,
+		},
+		{
+			name:    "not an ARN",
+			input:   "example",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, want error", tc.input, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %s", tc.input, err)
+			}
+
+			if got != tc.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
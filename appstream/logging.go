@@ -0,0 +1,140 @@
+package appstream
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// resourceNameFields are the input fields that identify the resource an
+// AppStream operation acts on, in order of preference.
+var resourceNameFields = []string{"Name", "StackName", "FleetName", "DirectoryName", "ResourceArn"}
+
+// apiCallLogHandler logs one line per AppStream API call once all of its
+// attempts are done. Request parameters are rendered with every member the
+// API model marks as sensitive redacted.
+var apiCallLogHandler = request.NamedHandler{
+	Name: "appstream.APICallLogHandler",
+	Fn: func(r *request.Request) {
+		var buf bytes.Buffer
+
+		fmt.Fprintf(&buf, "[DEBUG] Appstream API call: operation=%s", r.Operation.Name)
+
+		if name := apiCallResourceName(r.Params); name != "" {
+			fmt.Fprintf(&buf, " resource=%s", name)
+		}
+
+		fmt.Fprintf(&buf, " region=%s duration=%s retries=%d", aws.StringValue(r.Config.Region), time.Since(r.Time).Round(time.Millisecond), r.RetryCount)
+
+		if r.RequestID != "" {
+			fmt.Fprintf(&buf, " request_id=%s", r.RequestID)
+		}
+
+		if r.Error != nil {
+			code := "Unknown"
+			if err, ok := r.Error.(awserr.Error); ok {
+				code = err.Code()
+			}
+			fmt.Fprintf(&buf, " error_code=%s", code)
+		}
+
+		buf.WriteString(" params=")
+		writeRedacted(&buf, reflect.ValueOf(r.Params))
+
+		log.Print(buf.String())
+	},
+}
+
+func apiCallResourceName(params interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(params))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+
+	for _, field := range resourceNameFields {
+		if fv := v.FieldByName(field); fv.IsValid() && fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			return fmt.Sprint(fv.Elem().Interface())
+		}
+	}
+
+	return ""
+}
+
+// writeRedacted renders an API shape on a single line, replacing the value
+// of every field tagged sensitive by the API model.
+func writeRedacted(buf *bytes.Buffer, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			buf.WriteString("null")
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		buf.WriteString("{")
+		first := true
+		for i := 0; i < v.NumField(); i++ {
+			ft := v.Type().Field(i)
+			fv := v.Field(i)
+
+			if ft.PkgPath != "" {
+				continue
+			}
+			if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && fv.IsNil() {
+				continue
+			}
+
+			if !first {
+				buf.WriteString(" ")
+			}
+			first = false
+
+			buf.WriteString(ft.Name + ":")
+			if ft.Tag.Get("sensitive") == "true" {
+				buf.WriteString("<sensitive>")
+			} else {
+				writeRedacted(buf, fv)
+			}
+		}
+		buf.WriteString("}")
+	case reflect.Slice:
+		buf.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			writeRedacted(buf, v.Index(i))
+		}
+		buf.WriteString("]")
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("{")
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(k + ":")
+			writeRedacted(buf, v.MapIndex(reflect.ValueOf(k)))
+		}
+		buf.WriteString("}")
+	case reflect.String:
+		buf.WriteString(strconv.Quote(v.String()))
+	default:
+		fmt.Fprint(buf, v.Interface())
+	}
+}
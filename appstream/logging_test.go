@@ -0,0 +1,129 @@
+package appstream
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/appstream"
+)
+
+func TestWriteRedacted(t *testing.T) {
+	cases := []struct {
+		name    string
+		params  interface{}
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "directory config credentials",
+			params: &appstream.CreateDirectoryConfigInput{
+				DirectoryName:                        aws.String("corp.example.com"),
+				OrganizationalUnitDistinguishedNames: aws.StringSlice([]string{"OU=AppStream,DC=corp,DC=example,DC=com"}),
+				ServiceAccountCredentials: &appstream.ServiceAccountCredentials{
+					AccountName:     aws.String("CORP\\svc-appstream"),
+					AccountPassword: aws.String("hunter2"),
+				},
+			},
+			want: []string{
+				`DirectoryName:"corp.example.com"`,
+				`AccountName:<sensitive>`,
+				`AccountPassword:<sensitive>`,
+			},
+			notWant: []string{"hunter2", "svc-appstream"},
+		},
+		{
+			name: "fleet",
+			params: &appstream.CreateFleetInput{
+				ComputeCapacity: &appstream.ComputeCapacity{DesiredInstances: aws.Int64(2)},
+				DomainJoinInfo: &appstream.DomainJoinInfo{
+					DirectoryName: aws.String("corp.example.com"),
+				},
+				ImageName:    aws.String("example-image"),
+				InstanceType: aws.String("stream.standard.medium"),
+				Name:         aws.String("example-fleet"),
+			},
+			want: []string{
+				`Name:"example-fleet"`,
+				`ImageName:"example-image"`,
+				`ComputeCapacity:{DesiredInstances:2}`,
+				`DomainJoinInfo:{DirectoryName:"corp.example.com"}`,
+			},
+			notWant: []string{"<sensitive>"},
+		},
+		{
+			name: "user stack associations",
+			params: &appstream.BatchAssociateUserStackInput{
+				UserStackAssociations: []*appstream.UserStackAssociation{
+					{
+						AuthenticationType: aws.String(appstream.AuthenticationTypeUserpool),
+						StackName:          aws.String("example-stack"),
+						UserName:           aws.String("jdoe@example.com"),
+					},
+				},
+			},
+			want: []string{
+				`StackName:"example-stack"`,
+				`UserName:<sensitive>`,
+			},
+			notWant: []string{"jdoe@example.com"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeRedacted(&buf, reflect.ValueOf(tc.params))
+			got := buf.String()
+
+			for _, s := range tc.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("writeRedacted() = %s, want it to contain %s", got, s)
+				}
+			}
+
+			for _, s := range tc.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("writeRedacted() = %s, want it not to contain %s", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestAPICallResourceName(t *testing.T) {
+	cases := []struct {
+		name   string
+		params interface{}
+		want   string
+	}{
+		{
+			name:   "name",
+			params: &appstream.CreateFleetInput{Name: aws.String("example-fleet")},
+			want:   "example-fleet",
+		},
+		{
+			name: "stack name",
+			params: &appstream.AssociateFleetInput{
+				FleetName: aws.String("example-fleet"),
+				StackName: aws.String("example-stack"),
+			},
+			want: "example-stack",
+		},
+		{
+			name:   "none",
+			params: &appstream.DescribeFleetsInput{},
+			want:   "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := apiCallResourceName(tc.params); got != tc.want {
+				t.Errorf("apiCallResourceName() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
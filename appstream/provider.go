@@ -1,14 +1,19 @@
 package appstream
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
 	homedir "github.com/mitchellh/go-homedir"
 )
 
-func Provider() terraform.ResourceProvider {
+func Provider() *schema.Provider {
 
 	provider := &schema.Provider{
 		Schema: map[string]*schema.Schema{
@@ -35,6 +40,8 @@ func Provider() terraform.ResourceProvider {
 
 			"assume_role": assumeRoleSchema(),
 
+			"assume_role_with_web_identity": assumeRoleWithWebIdentitySchema(),
+
 			"shared_credentials_file": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -59,6 +66,168 @@ func Provider() terraform.ResourceProvider {
 				Description:  "Region",
 				InputDefault: "us-east-1",
 			},
+
+			"api_rate_limit": {
+				Type:         schema.TypeFloat,
+				Optional:     true,
+				Default:      5,
+				Description:  descriptions["api_rate_limit"],
+				ValidateFunc: validation.FloatAtLeast(0),
+			},
+
+			"api_burst": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      10,
+				Description:  descriptions["api_burst"],
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+
+			"allowed_account_ids": {
+				Type:          schema.TypeSet,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				Optional:      true,
+				ConflictsWith: []string{"forbidden_account_ids"},
+				Set:           schema.HashString,
+				Description:   descriptions["allowed_account_ids"],
+			},
+
+			"forbidden_account_ids": {
+				Type:          schema.TypeSet,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				Optional:      true,
+				ConflictsWith: []string{"allowed_account_ids"},
+				Set:           schema.HashString,
+				Description:   descriptions["forbidden_account_ids"],
+			},
+
+			"default_tags": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: descriptions["default_tags"],
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"tags": {
+							Type:        schema.TypeMap,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: descriptions["default_tags_tags"],
+						},
+					},
+				},
+			},
+
+			"endpoints": endpointsSchema(),
+
+			"custom_ca_bundle": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AWS_CA_BUNDLE", ""),
+				Description: descriptions["custom_ca_bundle"],
+			},
+
+			"http_proxy": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: descriptions["http_proxy"],
+			},
+
+			"insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["insecure"],
+			},
+
+			"use_fips_endpoint": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AWS_USE_FIPS_ENDPOINT", false),
+				Description: descriptions["use_fips_endpoint"],
+			},
+
+			"use_dualstack_endpoint": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("AWS_USE_DUALSTACK_ENDPOINT", false),
+				Description: descriptions["use_dualstack_endpoint"],
+			},
+
+			"ignore_tags": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: descriptions["ignore_tags"],
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"keys": {
+							Type:        schema.TypeSet,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Set:         schema.HashString,
+							Description: descriptions["ignore_tags_keys"],
+						},
+						"key_prefixes": {
+							Type:        schema.TypeSet,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Set:         schema.HashString,
+							Description: descriptions["ignore_tags_key_prefixes"],
+						},
+					},
+				},
+			},
+
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     25,
+				Description: descriptions["max_retries"],
+			},
+
+			"retry_mode": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     retryModeStandard,
+				Description: descriptions["retry_mode"],
+				ValidateFunc: validation.StringInSlice([]string{
+					retryModeStandard,
+					retryModeAdaptive,
+				}, false),
+			},
+
+			"skip_credentials_validation": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["skip_credentials_validation"],
+			},
+
+			"skip_region_validation": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["skip_region_validation"],
+			},
+
+			"skip_requesting_account_id": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["skip_requesting_account_id"],
+			},
+
+			"skip_metadata_api_check": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["skip_metadata_api_check"],
+			},
+		},
+
+		DataSourcesMap: map[string]*schema.Resource{
+			"appstream_caller_identity": dataSourceAppstreamCallerIdentity(),
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
@@ -68,14 +237,20 @@ func Provider() terraform.ResourceProvider {
 			"appstream_fleet":            resourceAppstreamFleet(),
 		},
 	}
-	provider.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
+	provider.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 		terraformVersion := provider.TerraformVersion
 		if terraformVersion == "" {
-			// Terraform 0.12 introduced this field to the protocol
-			// We can therefore assume that if it's missing it's 0.10 or 0.11
-			terraformVersion = "0.11+compatible"
+			// Every Terraform version supported by SDK v2 sends this field,
+			// it is only missing when the provider is run outside Terraform
+			terraformVersion = "0.12+compatible"
 		}
-		return providerConfigure(d, terraformVersion)
+
+		client, err := providerConfigure(d, terraformVersion)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+
+		return client, nil
 	}
 	return provider
 }
@@ -106,11 +281,49 @@ func init() {
 			"being executed. If the API request still fails, an error is\n" +
 			"thrown.",
 
+		"api_rate_limit": "The maximum number of AWS API requests per second shared by all\n" +
+			"resources of the provider, per region. Set to 0 to disable client-side rate limiting.",
+
+		"api_burst": "The number of AWS API requests that can be sent at once before\n" +
+			"`api_rate_limit` applies.",
+
+		"allowed_account_ids": "List of allowed AWS account IDs. The provider refuses to\n" +
+			"configure when the credentials belong to any other account.",
+
+		"forbidden_account_ids": "List of forbidden AWS account IDs. The provider refuses to\n" +
+			"configure when the credentials belong to one of them.",
+
+		"default_tags": "Configuration block with settings to default resource tags across all resources.",
+
+		"default_tags_tags": "Resource tags to default across all resources.",
+
+		"ignore_tags": "Configuration block with settings to ignore resource tags across all resources.",
+
+		"ignore_tags_keys": "Resource tag keys to ignore across all resources.",
+
+		"ignore_tags_key_prefixes": "Resource tag key prefixes to ignore across all resources.",
+
+		"retry_mode": "Specifies how retries are attempted. With `standard` only the failed\n" +
+			"request is delayed, with `adaptive` every AppStream request is held back\n" +
+			"after a throttling error.",
+
 		"endpoint": "Use this to override the default service endpoint URL",
 
+		"custom_ca_bundle": "The path to a file containing PEM encoded CA certificates trusted\n" +
+			"for AWS API requests, e.g. the certificate of a TLS-inspecting proxy.",
+
+		"http_proxy": "The URL of the proxy used for AWS API requests. If omitted, the\n" +
+			"HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.",
+
 		"insecure": "Explicitly allow the provider to perform \"insecure\" SSL requests. If omitted," +
 			"default value is `false`",
 
+		"use_fips_endpoint": "Resolve the AppStream and STS endpoints to their FIPS variant,\n" +
+			"e.g. appstream2-fips.us-gov-west-1.amazonaws.com.",
+
+		"use_dualstack_endpoint": "Resolve the AppStream and STS endpoints to their dual-stack\n" +
+			"(IPv4 and IPv6) variant, e.g. appstream2.us-east-1.api.aws.",
+
 		"skip_credentials_validation": "Skip the credentials validation via STS API. " +
 			"Used for AWS API implementations that do not have STS available/implemented.",
 
@@ -123,7 +336,7 @@ func init() {
 		"skip_requesting_account_id": "Skip requesting the account ID. " +
 			"Used for AWS API implementations that do not have IAM/STS API and/or metadata API.",
 
-		"skip_medatadata_api_check": "Skip the AWS Metadata API check. " +
+		"skip_metadata_api_check": "Skip the AWS Metadata API check. " +
 			"Used for AWS API implementations that do not have a metadata api endpoint.",
 
 		"s3_force_path_style": "Set this to true to force the request to use path-style addressing,\n" +
@@ -131,6 +344,9 @@ func init() {
 			"use virtual hosted bucket addressing when possible\n" +
 			"(http://BUCKET.s3.amazonaws.com/KEY). Specific to the Amazon S3 service.",
 
+		"assume_role": "The IAM roles to assume prior to making API calls. Roles are assumed in order," +
+			" each one with the credentials of the previous one.",
+
 		"assume_role_role_arn": "The ARN of an IAM role to assume prior to making API calls.",
 
 		"assume_role_session_name": "The session name to use when assuming the role. If omitted," +
@@ -142,6 +358,25 @@ func init() {
 		"assume_role_policy": "The permissions applied when assuming a role. You cannot use," +
 			" this policy to grant further permissions that are in excess to those of the, " +
 			" role that is being assumed.",
+
+		"assume_role_duration": "The duration of the role session, between 15 minutes and 12 hours," +
+			" e.g. `1h` or `2h30m`. If omitted, the default of 1 hour is used.",
+
+		"assume_role_policy_arns": "Amazon Resource Names (ARNs) of IAM policies describing further" +
+			" restricting permissions for the role session.",
+
+		"assume_role_tags": "Session tags to pass when assuming the role.",
+
+		"assume_role_transitive_tag_keys": "Session tag keys to pass to any subsequent role sessions.",
+
+		"assume_role_with_web_identity_role_arn": "The ARN of an IAM role to assume with a web identity token" +
+			" prior to making API calls.",
+
+		"assume_role_with_web_identity_web_identity_token_file": "The path to a file containing the OAuth 2.0" +
+			" access token or OpenID Connect ID token issued by the identity provider.",
+
+		"assume_role_with_web_identity_session_name": "The session name to use when assuming the role" +
+			" with a web identity token.",
 	}
 }
 func providerConfigure(d *schema.ResourceData, terraformVersion string) (interface{}, error) {
@@ -151,7 +386,17 @@ func providerConfigure(d *schema.ResourceData, terraformVersion string) (interfa
 		Profile:          d.Get("profile").(string),
 		Token:            d.Get("token").(string),
 		Region:           d.Get("region").(string),
+		MaxRetries:       d.Get("max_retries").(int),
+		RetryMode:        d.Get("retry_mode").(string),
+		APIRateLimit:     d.Get("api_rate_limit").(float64),
+		APIBurst:         d.Get("api_burst").(int),
+		Endpoints:        make(map[string]string),
 		terraformVersion: terraformVersion,
+
+		SkipCredsValidation:     d.Get("skip_credentials_validation").(bool),
+		SkipRegionValidation:    d.Get("skip_region_validation").(bool),
+		SkipRequestingAccountId: d.Get("skip_requesting_account_id").(bool),
+		SkipMetadataApiCheck:    d.Get("skip_metadata_api_check").(bool),
 	}
 
 	// Set CredsFilename, expanding home directory
@@ -161,30 +406,127 @@ func providerConfigure(d *schema.ResourceData, terraformVersion string) (interfa
 	}
 	config.CredsFilename = credsPath
 
-	assumeRoleList := d.Get("assume_role").(*schema.Set).List()
-	if len(assumeRoleList) == 1 {
-		assumeRole := assumeRoleList[0].(map[string]interface{})
-		config.AssumeRoleARN = assumeRole["role_arn"].(string)
-		config.AssumeRoleSessionName = assumeRole["session_name"].(string)
-		config.AssumeRoleExternalID = assumeRole["external_id"].(string)
+	for i, assumeRoleRaw := range d.Get("assume_role").([]interface{}) {
+		if assumeRoleRaw == nil {
+			continue
+		}
+
+		assumeRole := assumeRoleRaw.(map[string]interface{})
+		if assumeRole["role_arn"].(string) == "" {
+			log.Printf("[INFO] Skipping assume_role block %d without role_arn", i+1)
+			continue
+		}
+
+		hop := AssumeRole{
+			ARN:         assumeRole["role_arn"].(string),
+			SessionName: assumeRole["session_name"].(string),
+			ExternalID:  assumeRole["external_id"].(string),
+			Policy:      assumeRole["policy"].(string),
+		}
+
+		if v := assumeRole["duration"].(string); v != "" {
+			duration, _ := time.ParseDuration(v)
+			hop.DurationSeconds = int(duration.Seconds())
+		}
+
+		for _, policyARNRaw := range assumeRole["policy_arns"].(*schema.Set).List() {
+			hop.PolicyARNs = append(hop.PolicyARNs, policyARNRaw.(string))
+		}
 
-		if v := assumeRole["policy"].(string); v != "" {
-			config.AssumeRolePolicy = v
+		if v := assumeRole["tags"].(map[string]interface{}); len(v) > 0 {
+			hop.Tags = expandTags(v)
 		}
 
-		log.Printf("[INFO] assume_role configuration set: (ARN: %q, SessionID: %q, ExternalID: %q, Policy: %q)",
-			config.AssumeRoleARN, config.AssumeRoleSessionName, config.AssumeRoleExternalID, config.AssumeRolePolicy)
-	} else {
+		for _, tagKeyRaw := range assumeRole["transitive_tag_keys"].(*schema.Set).List() {
+			hop.TransitiveTagKeys = append(hop.TransitiveTagKeys, tagKeyRaw.(string))
+		}
+
+		config.AssumeRoles = append(config.AssumeRoles, hop)
+
+		log.Printf("[INFO] assume_role configuration set for hop %d: (ARN: %q, SessionID: %q, ExternalID: %q, Policy: %q, DurationSeconds: %d)",
+			len(config.AssumeRoles), hop.ARN, hop.SessionName, hop.ExternalID, hop.Policy, hop.DurationSeconds)
+	}
+
+	if len(config.AssumeRoles) == 0 {
 		log.Printf("[INFO] No assume_role block read from configuration")
 	}
+
+	if l, ok := d.Get("assume_role_with_web_identity").([]interface{}); ok && len(l) > 0 && l[0] != nil {
+		assumeRoleWithWebIdentity := l[0].(map[string]interface{})
+		config.AssumeRoleWithWebIdentityARN = assumeRoleWithWebIdentity["role_arn"].(string)
+		config.AssumeRoleWithWebIdentitySessionName = assumeRoleWithWebIdentity["session_name"].(string)
+
+		tokenFile, err := homedir.Expand(assumeRoleWithWebIdentity["web_identity_token_file"].(string))
+		if err != nil {
+			return nil, err
+		}
+		config.AssumeRoleWithWebIdentityTokenFile = tokenFile
+
+		log.Printf("[INFO] assume_role_with_web_identity configuration set: (ARN: %q, SessionID: %q, TokenFile: %q)",
+			config.AssumeRoleWithWebIdentityARN, config.AssumeRoleWithWebIdentitySessionName, config.AssumeRoleWithWebIdentityTokenFile)
+	}
+
+	if v, ok := d.GetOk("allowed_account_ids"); ok {
+		for _, accountIDRaw := range v.(*schema.Set).List() {
+			config.AllowedAccountIds = append(config.AllowedAccountIds, accountIDRaw.(string))
+		}
+	}
+
+	if v, ok := d.GetOk("forbidden_account_ids"); ok {
+		for _, accountIDRaw := range v.(*schema.Set).List() {
+			config.ForbiddenAccountIds = append(config.ForbiddenAccountIds, accountIDRaw.(string))
+		}
+	}
+
+	if l, ok := d.Get("default_tags").([]interface{}); ok && len(l) > 0 && l[0] != nil {
+		defaultTags := l[0].(map[string]interface{})
+
+		if v, ok := defaultTags["tags"].(map[string]interface{}); ok {
+			config.DefaultTags = expandTags(v)
+		}
+	}
+
+	if l, ok := d.Get("ignore_tags").([]interface{}); ok && len(l) > 0 && l[0] != nil {
+		ignoreTags := l[0].(map[string]interface{})
+
+		for _, keyRaw := range ignoreTags["keys"].(*schema.Set).List() {
+			config.IgnoreTags = append(config.IgnoreTags, keyRaw.(string))
+		}
+
+		for _, keyPrefixRaw := range ignoreTags["key_prefixes"].(*schema.Set).List() {
+			config.IgnoreTagPrefixes = append(config.IgnoreTagPrefixes, keyPrefixRaw.(string))
+		}
+	}
+
+	if v := d.Get("custom_ca_bundle").(string); v != "" {
+		caBundlePath, err := homedir.Expand(v)
+		if err != nil {
+			return nil, err
+		}
+		config.CustomCABundle = caBundlePath
+	}
+
+	config.HTTPProxy = d.Get("http_proxy").(string)
+	config.Insecure = d.Get("insecure").(bool)
+	config.UseFIPSEndpoint = d.Get("use_fips_endpoint").(bool)
+	config.UseDualStackEndpoint = d.Get("use_dualstack_endpoint").(bool)
+
+	endpointsSet := d.Get("endpoints").(*schema.Set)
+	for _, endpointsSetI := range endpointsSet.List() {
+		endpoints := endpointsSetI.(map[string]interface{})
+		for _, endpointServiceName := range endpointServiceNames {
+			config.Endpoints[endpointServiceName] = endpoints[endpointServiceName].(string)
+		}
+	}
+
 	return config.Client()
 }
 
 func assumeRoleSchema() *schema.Schema {
 	return &schema.Schema{
-		Type:     schema.TypeSet,
-		Optional: true,
-		MaxItems: 1,
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: descriptions["assume_role"],
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				"role_arn": {
@@ -210,7 +552,113 @@ func assumeRoleSchema() *schema.Schema {
 					Optional:    true,
 					Description: descriptions["assume_role_policy"],
 				},
+
+				"duration": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Description:  descriptions["assume_role_duration"],
+					ValidateFunc: validateAssumeRoleDuration,
+				},
+
+				"policy_arns": {
+					Type:        schema.TypeSet,
+					Optional:    true,
+					Elem:        &schema.Schema{Type: schema.TypeString},
+					Set:         schema.HashString,
+					Description: descriptions["assume_role_policy_arns"],
+				},
+
+				"tags": {
+					Type:        schema.TypeMap,
+					Optional:    true,
+					Elem:        &schema.Schema{Type: schema.TypeString},
+					Description: descriptions["assume_role_tags"],
+				},
+
+				"transitive_tag_keys": {
+					Type:        schema.TypeSet,
+					Optional:    true,
+					Elem:        &schema.Schema{Type: schema.TypeString},
+					Set:         schema.HashString,
+					Description: descriptions["assume_role_transitive_tag_keys"],
+				},
+			},
+		},
+	}
+}
+
+func assumeRoleWithWebIdentitySchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"role_arn": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: descriptions["assume_role_with_web_identity_role_arn"],
+				},
+
+				"web_identity_token_file": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: descriptions["assume_role_with_web_identity_web_identity_token_file"],
+				},
+
+				"session_name": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: descriptions["assume_role_with_web_identity_session_name"],
+				},
 			},
 		},
 	}
 }
+
+// validateAssumeRoleDuration checks that the value is a duration accepted
+// by STS AssumeRole, between 15 minutes and 12 hours.
+func validateAssumeRoleDuration(v interface{}, k string) (ws []string, errors []error) {
+	duration, err := time.ParseDuration(v.(string))
+	if err != nil {
+		errors = append(errors, fmt.Errorf("%q cannot be parsed as a duration: %s", k, err))
+		return
+	}
+
+	if duration < 15*time.Minute || duration > 12*time.Hour {
+		errors = append(errors, fmt.Errorf("%q must be between 15 minutes (15m) and 12 hours (12h), got: %s", k, duration))
+	}
+
+	return
+}
+
+// endpointServiceNames lists the services whose endpoint can be overridden
+// through the provider endpoints block.
+var endpointServiceNames = []string{
+	"appstream",
+	"iam",
+	"imagebuilder",
+	"sts",
+}
+
+func endpointsSchema() *schema.Schema {
+	endpointsAttributes := make(map[string]*schema.Schema)
+
+	for _, endpointServiceName := range endpointServiceNames {
+		endpointsAttributes[endpointServiceName] = &schema.Schema{
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     "",
+			Description: descriptions["endpoint"],
+		}
+	}
+
+	return &schema.Schema{
+		Type:     schema.TypeSet,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: endpointsAttributes,
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package appstream
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"golang.org/x/time/rate"
+)
+
+// apiRateLimiter is a token bucket shared by every request sent through the
+// provider session, with one bucket per region.
+type apiRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newAPIRateLimiter(limit float64, burst int) *apiRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &apiRateLimiter{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (l *apiRateLimiter) limiter(region string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[region]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[region] = limiter
+	}
+
+	return limiter
+}
+
+// handler returns a Sign handler that waits for a token before every
+// attempt, retries included, so the request is signed once it may be sent.
+func (l *apiRateLimiter) handler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "appstream.APIRateLimiter",
+		Fn: func(r *request.Request) {
+			if err := l.limiter(aws.StringValue(r.Config.Region)).Wait(r.Context()); err != nil {
+				r.Error = err
+			}
+		},
+	}
+}
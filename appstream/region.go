@@ -0,0 +1,46 @@
+package appstream
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/appstream"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// regionSchema returns the optional region attribute that overrides the
+// provider region for a single resource.
+func regionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+		Computed: true,
+		ForceNew: true,
+	}
+}
+
+// resourceRegion returns the region of the resource, falling back to the
+// provider region when the region attribute is not set.
+func resourceRegion(d *schema.ResourceData, meta interface{}) string {
+	if v, ok := d.GetOk("region"); ok {
+		return v.(string)
+	}
+
+	return meta.(*AWSClient).region
+}
+
+// resourceAppstreamConn returns the AppStream client for the resource region.
+func resourceAppstreamConn(d *schema.ResourceData, meta interface{}) *appstream.AppStream {
+	return meta.(*AWSClient).AppstreamConnForRegion(resourceRegion(d, meta))
+}
+
+// importStateWithRegion imports resources by ID, optionally suffixed with
+// the region of the resource, e.g. NAME@eu-west-1.
+func importStateWithRegion(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	if i := strings.LastIndex(d.Id(), "@"); i >= 0 {
+		d.Set("region", d.Id()[i+1:])
+		d.SetId(d.Id()[:i])
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
@@ -1,26 +1,47 @@
 package appstream
 
 import (
+	"context"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/appstream"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/julian3xl/terraform-provider-appstream/appstream/internal/arn"
 )
 
 func resourceAppstreamFleet() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceAppstreamFleetCreate,
-		Read:   resourceAppstreamFleetRead,
-		Update: resourceAppstreamFleetUpdate,
-		Delete: resourceAppstreamFleetDelete,
+		CreateContext: resourceAppstreamFleetCreate,
+		ReadContext:   resourceAppstreamFleetRead,
+		UpdateContext: resourceAppstreamFleetUpdate,
+		DeleteContext: resourceAppstreamFleetDelete,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			StateContext: importStateWithARN(arn.ResourceTypeFleet),
 		},
 
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		CustomizeDiff: customdiff.Sequence(
+			setTagsDiff,
+			fleetImageRolloutDiff,
+			fleetPendingDeletionDiff,
+		),
+
 		Schema: map[string]*schema.Schema{
+			"arn": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"compute_capacity": {
 				Type:     schema.TypeList,
 				Required: true,
@@ -34,6 +55,36 @@ func resourceAppstreamFleet() *schema.Resource {
 				},
 			},
 
+			"compute_capacity_status": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"available": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"desired": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"in_use": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"running": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			"created_time": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"description": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -71,6 +122,31 @@ func resourceAppstreamFleet() *schema.Resource {
 				Optional: true,
 			},
 
+			"fleet_errors": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"error_code": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"error_message": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			// fleet_name is the name of the AppStream fleet, which differs
+			// from name once a blue/green image rollout replaced the fleet.
+			// Stack attachments of such fleets must reference it.
+			"fleet_name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"fleet_type": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -86,17 +162,42 @@ func resourceAppstreamFleet() *schema.Resource {
 				Optional: true,
 			},
 
-			// TO-BE-REPAIRED: there is some inconsistency between sdk and api that makes it messy right now
-			//"image_arn": {
-			//	Type:          schema.TypeString,
-			//	Optional:      true,
-			//	ConflictsWith: []string{"image_name"},
-			//},
+			"image_arn": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"image_arn", "image_name"},
+				ValidateFunc: validateAppstreamARN(arn.ResourceTypeImage),
+			},
 
 			"image_name": {
-				Type:     schema.TypeString,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"image_arn", "image_name"},
+			},
+
+			"image_rollout": {
+				Type:     schema.TypeList,
 				Optional: true,
-				//ConflictsWith: []string{"image_arn"},
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"strategy": {
+							Type:     schema.TypeString,
+							Required: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								fleetImageRolloutInPlace,
+								fleetImageRolloutBlueGreen,
+							}, false),
+						},
+						// drain_timeout is how long a replaced fleet waits
+						// for its user sessions to end before it is stopped.
+						"drain_timeout": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+					},
+				},
 			},
 
 			"instance_type": {
@@ -112,8 +213,19 @@ func resourceAppstreamFleet() *schema.Resource {
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 
+			// pending_deletion_fleet_names holds the fleets a blue/green
+			// image rollout could not delete, the next apply deletes them.
+			"pending_deletion_fleet_names": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"region": regionSchema(),
+
 			"state": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -130,6 +242,12 @@ func resourceAppstreamFleet() *schema.Resource {
 				Optional: true,
 			},
 
+			"tags_all": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
 			"vpc_config": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -152,8 +270,45 @@ func resourceAppstreamFleet() *schema.Resource {
 	}
 }
 
-func resourceAppstreamFleetCreate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamFleetCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
+	CreateFleetInputOpts := expandFleetCreateInput(d, meta)
+
+	_, err := svc.CreateFleetWithContext(ctx, CreateFleetInputOpts)
+	if err != nil {
+		log.Printf("[ERROR] Error creating Appstream Fleet: %s", err)
+		return diag.FromErr(err)
+	}
+
+	// The fleet exists from now on, record it before waiting so that a
+	// cancelled or timed out apply does not lose track of it.
+	d.SetId(aws.StringValue(CreateFleetInputOpts.Name))
+
+	if v, ok := d.GetOk("state"); ok {
+		if v == "RUNNING" {
+			_, err := svc.StartFleetWithContext(ctx, &appstream.StartFleetInput{
+				Name: CreateFleetInputOpts.Name,
+			}, retryOnNotFound, retryOnStateTransition)
+
+			if err != nil {
+				log.Printf("[ERROR] Error satrting Appstream Fleet: %s", err)
+				return diag.FromErr(err)
+			}
+
+			if state, err := waitForFleetState(ctx, svc, d.Id(), "RUNNING", d.Timeout(schema.TimeoutCreate)); err != nil {
+				log.Printf("[ERROR] %s", err)
+				d.Set("state", state)
+				return diag.FromErr(err)
+			}
+		}
+	}
+
+	return resourceAppstreamFleetRead(ctx, d, meta)
+}
+
+// expandFleetCreateInput builds the CreateFleet input from the resource
+// configuration.
+func expandFleetCreateInput(d *schema.ResourceData, meta interface{}) *appstream.CreateFleetInput {
 	CreateFleetInputOpts := &appstream.CreateFleetInput{}
 
 	ComputeConfig := &appstream.ComputeCapacity{}
@@ -180,20 +335,8 @@ func resourceAppstreamFleetCreate(d *schema.ResourceData, meta interface{}) erro
 		CreateFleetInputOpts.DisplayName = aws.String(v.(string))
 	}
 
-	DomainJoinInfoConfig := &appstream.DomainJoinInfo{}
-	if dom, ok := d.GetOk("domain_info"); ok {
-		DomainAttributes := dom.([]interface{})
-		attr := DomainAttributes[0].(map[string]interface{})
-
-		if v, ok := attr["directory_name"]; ok {
-			DomainJoinInfoConfig.DirectoryName = aws.String(v.(string))
-		}
-
-		if v, ok := attr["organizational_unit_distinguished_name"]; ok {
-			DomainJoinInfoConfig.OrganizationalUnitDistinguishedName = aws.String(v.(string))
-		}
-
-		CreateFleetInputOpts.DomainJoinInfo = DomainJoinInfoConfig
+	if v, ok := d.GetOk("domain_info"); ok {
+		CreateFleetInputOpts.DomainJoinInfo = expandDomainJoinInfo(v.([]interface{}))
 	}
 
 	if v, ok := d.GetOk("enable_default_internet_access"); ok {
@@ -212,9 +355,9 @@ func resourceAppstreamFleetCreate(d *schema.ResourceData, meta interface{}) erro
 		CreateFleetInputOpts.IdleDisconnectTimeoutInSeconds = aws.Int64(int64(v.(int)))
 	}
 
-	//if v, ok := d.GetOk("image_arn"); ok {
-	//	CreateFleetInputOpts.ImageArn = aws.String(v.(string))
-	//}
+	if v, ok := d.GetOk("image_arn"); ok {
+		CreateFleetInputOpts.ImageArn = aws.String(v.(string))
+	}
 
 	if v, ok := d.GetOk("image_name"); ok {
 		CreateFleetInputOpts.ImageName = aws.String(v.(string))
@@ -240,133 +383,147 @@ func resourceAppstreamFleetCreate(d *schema.ResourceData, meta interface{}) erro
 		CreateFleetInputOpts.VpcConfig = expandVpcConfigs(v.([]interface{}))
 	}
 
-	log.Printf("[DEBUG] Run configuration: %s", CreateFleetInputOpts)
+	if tags := meta.(*AWSClient).defaultTagsConfig.merge(expandTags(d.Get("tags").(map[string]interface{}))); len(tags) > 0 {
+		CreateFleetInputOpts.Tags = aws.StringMap(tags)
+	}
 
-	resp, err := svc.CreateFleet(CreateFleetInputOpts)
+	return CreateFleetInputOpts
+}
+
+func resourceAppstreamFleetRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
+
+	resp, err := svc.DescribeFleetsWithContext(ctx, &appstream.DescribeFleetsInput{})
 	if err != nil {
-		log.Printf("[ERROR] Error creating Appstream Fleet: %s", err)
-		return err
+		log.Printf("[ERROR] Error reading Appstream Fleet: %s", err)
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] Appstream Fleet created %s ", resp)
+	for _, v := range resp.Fleets {
+		if aws.StringValue(v.Name) == fleetName(d) {
+			if v.ComputeCapacityStatus != nil {
+				if err := d.Set("compute_capacity", []interface{}{map[string]interface{}{
+					"desired_instances": int(aws.Int64Value(v.ComputeCapacityStatus.Desired)),
+				}}); err != nil {
+					return diag.FromErr(err)
+				}
+			}
 
-	if v, ok := d.GetOk("tags"); ok {
-		time.Sleep(2 * time.Second)
+			if err := d.Set("compute_capacity_status", flattenComputeCapacityStatus(v.ComputeCapacityStatus)); err != nil {
+				return diag.FromErr(err)
+			}
 
-		fleet_name := aws.StringValue(CreateFleetInputOpts.Name)
-		get, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-			Names: aws.StringSlice([]string{fleet_name}),
-		})
+			if v.CreatedTime != nil {
+				if err := d.Set("created_time", aws.TimeValue(v.CreatedTime).Format(time.RFC3339)); err != nil {
+					return diag.FromErr(err)
+				}
+			}
 
-		if err != nil {
-			log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-			return err
-		}
+			if err := d.Set("description", v.Description); err != nil {
+				return diag.FromErr(err)
+			}
 
-		if get.Fleets == nil {
-			log.Printf("[DEBUG] Appstream Fleet (%s) not found", d.Id())
-		}
+			if err := d.Set("disconnect_timeout", v.DisconnectTimeoutInSeconds); err != nil {
+				return diag.FromErr(err)
+			}
 
-		tag, err := svc.TagResource(&appstream.TagResourceInput{
-			ResourceArn: get.Fleets[0].Arn,
-			Tags:        aws.StringMap(expandTags(v.(map[string]interface{}))),
-		})
+			if err := d.Set("display_name", v.DisplayName); err != nil {
+				return diag.FromErr(err)
+			}
 
-		if err != nil {
-			log.Printf("[ERROR] Error tagging Appstream Fleet: %s", err)
-			return err
-		}
+			if err := d.Set("domain_info", flattenDomainJoinInfo(v.DomainJoinInfo)); err != nil {
+				return diag.FromErr(err)
+			}
 
-		log.Printf("[DEBUG] %s", tag)
-	}
+			if err := d.Set("enable_default_internet_access", v.EnableDefaultInternetAccess); err != nil {
+				return diag.FromErr(err)
+			}
 
-	if v, ok := d.GetOk("state"); ok {
-		if v == "RUNNING" {
-			resp, err := svc.StartFleet(&appstream.StartFleetInput{
-				Name: CreateFleetInputOpts.Name,
-			})
+			if err := d.Set("fleet_errors", flattenFleetErrors(v.FleetErrors)); err != nil {
+				return diag.FromErr(err)
+			}
 
-			if err != nil {
-				log.Printf("[ERROR] Error satrting Appstream Fleet: %s", err)
-				return err
+			if err := d.Set("fleet_name", v.Name); err != nil {
+				return diag.FromErr(err)
 			}
 
-			log.Printf("[DEBUG] %s", resp)
+			if err := d.Set("fleet_type", v.FleetType); err != nil {
+				return diag.FromErr(err)
+			}
 
-			for {
-				resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-					Names: aws.StringSlice([]string{*CreateFleetInputOpts.Name}),
-				})
+			if err := d.Set("iam_role_arn", v.IamRoleArn); err != nil {
+				return diag.FromErr(err)
+			}
 
-				if err != nil {
-					log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-					return err
-				}
+			if err := d.Set("idle_disconnect_timeout", v.IdleDisconnectTimeoutInSeconds); err != nil {
+				return diag.FromErr(err)
+			}
 
-				curr_state := resp.Fleets[0].State
-				if aws.StringValue(curr_state) == v {
-					break
+			// The API returns both the image name and ARN, only the one
+			// referenced in the configuration is kept. Imported fleets
+			// reference their image by name.
+			if _, ok := d.GetOk("image_arn"); ok {
+				if err := d.Set("image_arn", v.ImageArn); err != nil {
+					return diag.FromErr(err)
 				}
+			} else {
+				if err := d.Set("image_name", v.ImageName); err != nil {
+					return diag.FromErr(err)
+				}
+			}
+
+			if err := d.Set("instance_type", v.InstanceType); err != nil {
+				return diag.FromErr(err)
+			}
 
-				if aws.StringValue(curr_state) != v {
-					time.Sleep(20 * time.Second)
-					continue
+			if err := d.Set("max_user_duration", v.MaxUserDurationInSeconds); err != nil {
+				return diag.FromErr(err)
+			}
+
+			// Fleets replaced by a blue/green image rollout keep the
+			// configured name, see fleetRolloutName.
+			if _, ok := d.GetOk("name"); !ok {
+				if err := d.Set("name", v.Name); err != nil {
+					return diag.FromErr(err)
 				}
 			}
-		}
-	}
 
-	d.SetId(*CreateFleetInputOpts.Name)
+			if err := d.Set("state", v.State); err != nil {
+				return diag.FromErr(err)
+			}
 
-	return resourceAppstreamFleetRead(d, meta)
-}
+			if err := d.Set("stream_view", v.StreamView); err != nil {
+				return diag.FromErr(err)
+			}
 
-func resourceAppstreamFleetRead(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+			if err := d.Set("vpc_config", flattenVpcConfig(v.VpcConfig)); err != nil {
+				return diag.FromErr(err)
+			}
 
-	resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{})
-	if err != nil {
-		log.Printf("[ERROR] Error reading Appstream Fleet: %s", err)
-		return err
-	}
+			if err := d.Set("region", resourceRegion(d, meta)); err != nil {
+				return diag.FromErr(err)
+			}
 
-	for _, v := range resp.Fleets {
-		if aws.StringValue(v.Name) == d.Get("name") {
-			if v.ComputeCapacityStatus != nil {
-				comp_attr := map[string]interface{}{}
-				comp_attr["desired_instances"] = aws.Int64Value(v.ComputeCapacityStatus.Desired)
-				d.Set("compute_capacity", comp_attr)
-			}
-
-			d.Set("description", v.Description)
-			d.Set("disconnect_timeout", v.DisconnectTimeoutInSeconds)
-			d.Set("display_name", v.DisplayName)
-
-			if v.DomainJoinInfo != nil {
-				dom_attr := map[string]interface{}{}
-				dom_attr["directory_name"] = v.DomainJoinInfo.DirectoryName
-				dom_attr["organizational_unit_distinguished_name"] = v.DomainJoinInfo.OrganizationalUnitDistinguishedName
-				d.Set("domain_info", dom_attr)
-			}
-
-			d.Set("enable_default_internet_access", v.EnableDefaultInternetAccess)
-			d.Set("fleet_type", v.FleetType)
-			d.Set("iam_role_arn", v.IamRoleArn)
-			d.Set("idle_disconnect_timeout", v.IdleDisconnectTimeoutInSeconds)
-			//d.Set("image_arn", v.ImageArn)
-			d.Set("image_name", v.ImageName)
-			d.Set("instance_type", v.InstanceType)
-			d.Set("max_user_duration", v.MaxUserDurationInSeconds)
-			d.Set("name", v.Name)
-			d.Set("stream_view", v.StreamView)
-
-			tg, err := svc.ListTagsForResource(&appstream.ListTagsForResourceInput{
+			if err := d.Set("arn", v.Arn); err != nil {
+				return diag.FromErr(err)
+			}
+
+			pending, err := listFleetsPendingDeletion(ctx, d, svc)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+
+			if err := d.Set("pending_deletion_fleet_names", pending); err != nil {
+				return diag.FromErr(err)
+			}
+
+			tg, err := svc.ListTagsForResourceWithContext(ctx, &appstream.ListTagsForResourceInput{
 				ResourceArn: v.Arn,
 			})
 
 			if err != nil {
 				log.Printf("[ERROR] Error listing stack tags: %s", err)
-				return err
+				return diag.FromErr(err)
 			}
 
 			if tg.Tags == nil {
@@ -374,27 +531,15 @@ func resourceAppstreamFleetRead(d *schema.ResourceData, meta interface{}) error
 				return nil
 			}
 
-			if len(tg.Tags) > 0 {
-				tags_attr := make(map[string]string)
-				tags := tg.Tags
-				for k, v := range tags {
-					tags_attr[k] = aws.StringValue(v)
-				}
-
-				d.Set("tags", tags_attr)
+			tags := flattenTags(tg.Tags, meta.(*AWSClient).ignoreTagsConfig)
+			if err := d.Set("tags", meta.(*AWSClient).defaultTagsConfig.resourceTags(tags, d.Get("tags").(map[string]interface{}))); err != nil {
+				return diag.FromErr(err)
 			}
 
-			if v.VpcConfig != nil {
-				vpc_attr := map[string]interface{}{}
-				vpc_config_sg := aws.StringValueSlice(v.VpcConfig.SecurityGroupIds)
-				vpc_config_sub := aws.StringValueSlice(v.VpcConfig.SubnetIds)
-				vpc_attr["security_group_ids"] = aws.String(strings.Join(vpc_config_sg, ","))
-				vpc_attr["subnet_ids"] = aws.String(strings.Join(vpc_config_sub, ","))
-				d.Set("vpc_config", vpc_attr)
+			if err := d.Set("tags_all", tags); err != nil {
+				return diag.FromErr(err)
 			}
 
-			d.Set("state", v.State)
-
 			return nil
 		}
 	}
@@ -404,241 +549,342 @@ func resourceAppstreamFleetRead(d *schema.ResourceData, meta interface{}) error
 	return nil
 }
 
-func resourceAppstreamFleetUpdate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamFleetUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
+	name := fleetName(d)
 	UpdateFleetInputOpts := &appstream.UpdateFleetInput{}
 
-	d.Partial(true)
+	if d.HasChange("pending_deletion_fleet_names") {
+		if err := deleteFleetsPendingDeletion(ctx, d, svc); err != nil {
+			log.Printf("[ERROR] %s", err)
+			d.Partial(true)
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChanges("image_arn", "image_name") && fleetImageRolloutStrategy(d) == fleetImageRolloutBlueGreen && d.Get("state").(string) == appstream.FleetStateRunning {
+		fleet, err := describeFleet(ctx, svc, name)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		if fleet != nil && aws.StringValue(fleet.State) == appstream.FleetStateRunning {
+			newName, err := rolloutFleetImage(ctx, d, meta)
+			if newName != "" {
+				// The new fleet replaced the old one, even if the
+				// old one could not be cleaned up.
+				d.Set("fleet_name", newName)
+			}
+
+			if err != nil {
+				log.Printf("[ERROR] Error rolling out Appstream Fleet image: %s", err)
+				if newName == "" {
+					// The rollout was rolled back, the fleet is unchanged.
+					resetFleetChanges(d)
+				}
+				return diag.FromErr(err)
+			}
+
+			return resourceAppstreamFleetRead(ctx, d, meta)
+		}
+	}
+
+	if d.HasChange("compute_capacity") {
+		log.Printf("[DEBUG] Modify Fleet")
+		if v := d.Get("compute_capacity").([]interface{}); len(v) > 0 && v[0] != nil {
+			UpdateFleetInputOpts.ComputeCapacity = &appstream.ComputeCapacity{
+				DesiredInstances: aws.Int64(int64(v[0].(map[string]interface{})["desired_instances"].(int))),
+			}
+		}
+	}
 
 	if d.HasChange("description") {
-		d.SetPartial("description")
 		log.Printf("[DEBUG] Modify Fleet")
 		description := d.Get("description").(string)
 		UpdateFleetInputOpts.Description = aws.String(description)
 	}
 
 	if d.HasChange("disconnect_timeout") {
-		d.SetPartial("disconnect_timeout")
 		log.Printf("[DEBUG] Modify Fleet")
 		disconnect_timeout := d.Get("disconnect_timeout").(int)
 		UpdateFleetInputOpts.DisconnectTimeoutInSeconds = aws.Int64(int64(disconnect_timeout))
 	}
 
 	if d.HasChange("display_name") {
-		d.SetPartial("display_name")
 		log.Printf("[DEBUG] Modify Fleet")
 		display_name := d.Get("display_name").(string)
 		UpdateFleetInputOpts.DisplayName = aws.String(display_name)
 	}
 
+	// Attributes removed from the configuration have to be deleted
+	// explicitly, UpdateFleet leaves attributes it is not given untouched.
+	if d.HasChange("domain_info") {
+		log.Printf("[DEBUG] Modify Fleet")
+		if v := d.Get("domain_info").([]interface{}); len(v) > 0 {
+			UpdateFleetInputOpts.DomainJoinInfo = expandDomainJoinInfo(v)
+		} else {
+			UpdateFleetInputOpts.AttributesToDelete = append(UpdateFleetInputOpts.AttributesToDelete, aws.String(appstream.FleetAttributeDomainJoinInfo))
+		}
+	}
+
 	if d.HasChange("enable_default_internet_access") {
-		d.SetPartial("enable_default_internet_access")
 		log.Printf("[DEBUG] Modify Fleet")
 		enable_default_internet_access := d.Get("enable_default_internet_access").(bool)
 		UpdateFleetInputOpts.EnableDefaultInternetAccess = aws.Bool(enable_default_internet_access)
 	}
 
 	if d.HasChange("iam_role_arn") {
-		d.SetPartial("iam_role_arn")
 		log.Printf("[DEBUG] Modify Fleet")
-		iam_role_arn := d.Get("iam_role_arn").(string)
-		UpdateFleetInputOpts.IamRoleArn = aws.String(iam_role_arn)
+		if iam_role_arn := d.Get("iam_role_arn").(string); iam_role_arn != "" {
+			UpdateFleetInputOpts.IamRoleArn = aws.String(iam_role_arn)
+		} else {
+			UpdateFleetInputOpts.AttributesToDelete = append(UpdateFleetInputOpts.AttributesToDelete, aws.String(appstream.FleetAttributeIamRoleArn))
+		}
 	}
 
 	if d.HasChange("idle_disconnect_timeout") {
-		d.SetPartial("idle_disconnect_timeout")
 		log.Printf("[DEBUG] Modify Fleet")
 		idle_disconnect_timeout_in_seconds := d.Get("idle_disconnect_timeout").(int)
 		UpdateFleetInputOpts.IdleDisconnectTimeoutInSeconds = aws.Int64(int64(idle_disconnect_timeout_in_seconds))
 	}
 
-	// TO-BE-REPAIRED: there is some inconsistency between sdk and api that makes it hard right now
-	//if d.HasChange("image_arn") {
-	//	d.SetPartial("image_arn")
-	//	log.Printf("[DEBUG] Modify Fleet")
-	//	image_arn := d.Get("image_arn").(string)
-	//	UpdateFleetInputOpts.ImageArn = aws.String(image_arn)
-	//}
+	// Switching between image_arn and image_name changes both, only the
+	// attribute still set is sent.
+	if image_arn := d.Get("image_arn").(string); d.HasChange("image_arn") && image_arn != "" {
+		log.Printf("[DEBUG] Modify Fleet")
+		UpdateFleetInputOpts.ImageArn = aws.String(image_arn)
+	}
 
-	if d.HasChange("image_name") {
-		d.SetPartial("image_name")
+	if image_name := d.Get("image_name").(string); d.HasChange("image_name") && image_name != "" {
 		log.Printf("[DEBUG] Modify Fleet")
-		image_name := d.Get("image_name").(string)
 		UpdateFleetInputOpts.ImageName = aws.String(image_name)
 	}
 
 	if d.HasChange("instance_type") {
-		d.SetPartial("instance_type")
 		log.Printf("[DEBUG] Modify Fleet")
 		instance_type := d.Get("instance_type").(string)
 		UpdateFleetInputOpts.InstanceType = aws.String(instance_type)
 	}
 
 	if d.HasChange("max_user_duration") {
-		d.SetPartial("max_user_duration")
 		log.Printf("[DEBUG] Modify Fleet")
 		max_user_duration := d.Get("max_user_duration").(int)
 		UpdateFleetInputOpts.MaxUserDurationInSeconds = aws.Int64(int64(max_user_duration))
 	}
 
-	if v, ok := d.GetOk("name"); ok {
-		UpdateFleetInputOpts.Name = aws.String(v.(string))
-	}
+	UpdateFleetInputOpts.Name = aws.String(name)
 
 	if d.HasChange("stream_view") {
-		d.SetPartial("stream_view")
 		log.Printf("[DEBUG] Modify Fleet")
 		stream_view := d.Get("stream_view").(string)
 		UpdateFleetInputOpts.StreamView = aws.String(stream_view)
 	}
 
-	resp, err := svc.UpdateFleet(UpdateFleetInputOpts)
-	if err != nil {
-		log.Printf("[ERROR] Error updating Appstream Fleet: %s", err)
-		return err
+	if d.HasChange("vpc_config") {
+		log.Printf("[DEBUG] Modify Fleet")
+		if v := d.Get("vpc_config").([]interface{}); len(v) > 0 {
+			UpdateFleetInputOpts.VpcConfig = expandVpcConfigs(v)
+		} else {
+			UpdateFleetInputOpts.AttributesToDelete = append(UpdateFleetInputOpts.AttributesToDelete, aws.String(appstream.FleetAttributeVpcConfiguration))
+		}
 	}
 
-	log.Printf("[DEBUG] Appstream Fleet updated %s ", resp)
+	// The VPC configuration and domain join info of a fleet can only be
+	// changed while it is stopped.
+	restart := false
+	if d.HasChanges("domain_info", "vpc_config") {
+		fleet, err := describeFleet(ctx, svc, name)
+		if err != nil {
+			return diag.FromErr(err)
+		}
 
-	if v, ok := d.GetOk("tags"); ok && d.HasChange("tags") {
-		time.Sleep(2 * time.Second)
+		state := ""
+		if fleet != nil {
+			state = aws.StringValue(fleet.State)
+		}
 
-		fleet_name := aws.StringValue(UpdateFleetInputOpts.Name)
-		get, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-			Names: aws.StringSlice([]string{fleet_name}),
-		})
+		// A fleet can only be stopped or updated once it is done starting
+		// or stopping.
+		switch state {
+		case appstream.FleetStateStarting:
+			state, err = waitForFleetState(ctx, svc, name, appstream.FleetStateRunning, d.Timeout(schema.TimeoutUpdate))
+		case appstream.FleetStateStopping:
+			state, err = waitForFleetState(ctx, svc, name, appstream.FleetStateStopped, d.Timeout(schema.TimeoutUpdate))
+		}
 
 		if err != nil {
-			log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-			return err
+			log.Printf("[ERROR] %s", err)
+			return diag.FromErr(err)
 		}
 
-		if get.Fleets == nil {
-			log.Printf("[DEBUG] Appstream Fleet (%s) not found", d.Id())
+		if state == appstream.FleetStateRunning {
+			log.Printf("[INFO] Stopping Appstream Fleet (%s) to update its VPC configuration and domain join info", name)
+
+			_, err := svc.StopFleetWithContext(ctx, &appstream.StopFleetInput{
+				Name: aws.String(name),
+			}, retryOnStateTransition)
+
+			if err != nil {
+				log.Printf("[ERROR] Error stopping Appstream Fleet: %s", err)
+				return diag.FromErr(err)
+			}
+
+			if _, err := waitForFleetState(ctx, svc, name, appstream.FleetStateStopped, d.Timeout(schema.TimeoutUpdate)); err != nil {
+				log.Printf("[ERROR] %s", err)
+				d.Partial(true)
+				return diag.FromErr(err)
+			}
+
+			restart = d.Get("state").(string) != appstream.FleetStateStopped
 		}
+	}
 
-		tag, err := svc.TagResource(&appstream.TagResourceInput{
-			ResourceArn: get.Fleets[0].Arn,
-			Tags:        aws.StringMap(expandTags(v.(map[string]interface{}))),
-		})
+	_, err := svc.UpdateFleetWithContext(ctx, UpdateFleetInputOpts)
+	if err != nil {
+		log.Printf("[ERROR] Error updating Appstream Fleet: %s", err)
+		diags := diag.FromErr(err)
+		d.Partial(true)
 
-		if err != nil {
-			log.Printf("[ERROR] Error tagging Appstream Fleet: %s", err)
-			return err
+		// Bring the fleet back to its previous state.
+		if restart {
+			_, err := svc.StartFleetWithContext(ctx, &appstream.StartFleetInput{
+				Name: aws.String(name),
+			}, retryOnStateTransition)
+
+			if err != nil {
+				log.Printf("[ERROR] Error starting Appstream Fleet: %s", err)
+				diags = append(diags, diag.Errorf("error restarting Appstream Fleet (%s), it is left STOPPED: %s", name, err)...)
+			}
 		}
 
-		log.Printf("[DEBUG] %s", tag)
+		return diags
 	}
 
-	desired_state := d.Get("state")
-	if d.HasChange("state") {
-		d.SetPartial("state")
+	if restart {
+		log.Printf("[INFO] Restarting Appstream Fleet (%s)", name)
 
-		if desired_state == "STOPPED" {
-			svc.StopFleet(&appstream.StopFleetInput{
-				Name: aws.String(d.Id()),
-			})
+		_, err := svc.StartFleetWithContext(ctx, &appstream.StartFleetInput{
+			Name: aws.String(name),
+		}, retryOnStateTransition)
 
-			for {
-				resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-					Names: aws.StringSlice([]string{*UpdateFleetInputOpts.Name}),
-				})
+		if err != nil {
+			log.Printf("[ERROR] Error starting Appstream Fleet: %s", err)
+			d.Set("state", appstream.FleetStateStopped)
+			return diag.FromErr(err)
+		}
 
-				if err != nil {
-					log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-					return err
-				}
+		if state, err := waitForFleetState(ctx, svc, name, appstream.FleetStateRunning, d.Timeout(schema.TimeoutUpdate)); err != nil {
+			log.Printf("[ERROR] %s", err)
+			d.Set("state", state)
+			return diag.FromErr(err)
+		}
+	}
 
-				curr_state := resp.Fleets[0].State
-				if aws.StringValue(curr_state) == desired_state {
-					break
-				}
+	if d.HasChange("tags_all") {
+		// Keep the old tags so that a failed tagging is retried.
+		o, n := d.GetChange("tags_all")
+		fleet_name := aws.StringValue(UpdateFleetInputOpts.Name)
+		get, err := svc.DescribeFleetsWithContext(ctx, &appstream.DescribeFleetsInput{
+			Names: aws.StringSlice([]string{fleet_name}),
+		})
 
-				if aws.StringValue(curr_state) != desired_state {
-					time.Sleep(20 * time.Second)
-					continue
-				}
-			}
-		} else if desired_state == "RUNNING" {
-			svc.StartFleet(&appstream.StartFleetInput{
-				Name: aws.String(d.Id()),
-			})
+		if err != nil {
+			log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
+		}
 
-			for {
-				resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-					Names: aws.StringSlice([]string{*UpdateFleetInputOpts.Name}),
-				})
+		if len(get.Fleets) == 0 {
+			d.Set("tags_all", o)
+			return diag.Errorf("Appstream Fleet (%s) not found", name)
+		}
 
-				if err != nil {
-					log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-					return err
-				}
+		if err := updateTags(ctx, svc, aws.StringValue(get.Fleets[0].Arn), o, n, meta.(*AWSClient).ignoreTagsConfig); err != nil {
+			log.Printf("[ERROR] Error tagging Appstream Fleet: %s", err)
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
+		}
+	}
 
-				curr_state := resp.Fleets[0].State
-				if aws.StringValue(curr_state) == desired_state {
-					break
-				}
+	desired_state := d.Get("state").(string)
+	if d.HasChange("state") && (desired_state == "STOPPED" || desired_state == "RUNNING") {
+		// A fleet already transitioning to the desired state is only
+		// waited for.
+		if o, _ := d.GetChange("state"); o.(string) != fleetPendingStates[desired_state] {
+			var err error
+			if desired_state == "STOPPED" {
+				_, err = svc.StopFleetWithContext(ctx, &appstream.StopFleetInput{
+					Name: aws.String(name),
+				}, retryOnStateTransition)
+			} else {
+				_, err = svc.StartFleetWithContext(ctx, &appstream.StartFleetInput{
+					Name: aws.String(name),
+				}, retryOnStateTransition)
+			}
 
-				if aws.StringValue(curr_state) != desired_state {
-					time.Sleep(20 * time.Second)
-					continue
-				}
+			if err != nil {
+				log.Printf("[ERROR] Error changing Appstream Fleet state: %s", err)
+				d.Set("state", o)
+				return diag.FromErr(err)
 			}
 		}
-	}
 
-	d.Partial(false)
+		if state, err := waitForFleetState(ctx, svc, name, desired_state, d.Timeout(schema.TimeoutUpdate)); err != nil {
+			log.Printf("[ERROR] %s", err)
+			// The fleet update went through, only the state transition
+			// is incomplete.
+			d.Set("state", state)
+			return diag.FromErr(err)
+		}
+	}
 
-	return resourceAppstreamFleetRead(d, meta)
+	return resourceAppstreamFleetRead(ctx, d, meta)
 }
 
-func resourceAppstreamFleetDelete(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamFleetDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
+	name := fleetName(d)
+
+	for _, v := range d.Get("pending_deletion_fleet_names").([]interface{}) {
+		if err := retireFleet(ctx, svc, v.(string), fleetImageRolloutDrainTimeout(d), d.Timeout(schema.TimeoutDelete)); err != nil {
+			log.Printf("[ERROR] Error deleting Appstream Fleet left behind by an image rollout: %s", err)
+			return diag.FromErr(err)
+		}
+	}
 
-	resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-		Names: aws.StringSlice([]string{*aws.String(d.Id())}),
+	resp, err := svc.DescribeFleetsWithContext(ctx, &appstream.DescribeFleetsInput{
+		Names: aws.StringSlice([]string{name}),
 	})
 
 	if err != nil {
 		log.Printf("[ERROR] Error reading Appstream Fleet: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
 	curr_state := aws.StringValue(resp.Fleets[0].State)
 
 	if curr_state == "RUNNING" {
-		desired_state := "STOPPED"
-		svc.StopFleet(&appstream.StopFleetInput{
-			Name: aws.String(d.Id()),
-		})
-		for {
+		_, err := svc.StopFleetWithContext(ctx, &appstream.StopFleetInput{
+			Name: aws.String(name),
+		}, retryOnStateTransition)
 
-			resp, err := svc.DescribeFleets(&appstream.DescribeFleetsInput{
-				Names: aws.StringSlice([]string{*aws.String(d.Id())}),
-			})
-			if err != nil {
-				log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
-				return err
-			}
+		if err != nil {
+			log.Printf("[ERROR] Error stopping Appstream Fleet: %s", err)
+			return diag.FromErr(err)
+		}
 
-			curr_state := resp.Fleets[0].State
-			if aws.StringValue(curr_state) == desired_state {
-				break
-			}
-			if aws.StringValue(curr_state) != desired_state {
-				time.Sleep(20 * time.Second)
-				continue
-			}
+		if _, err := waitForFleetState(ctx, svc, name, "STOPPED", d.Timeout(schema.TimeoutDelete)); err != nil {
+			log.Printf("[ERROR] %s", err)
+			return diag.FromErr(err)
 		}
 	}
 
-	del, err := svc.DeleteFleet(&appstream.DeleteFleetInput{
-		Name: aws.String(d.Id()),
-	})
+	_, err = svc.DeleteFleetWithContext(ctx, &appstream.DeleteFleetInput{
+		Name: aws.String(name),
+	}, retryOnStateTransition)
 	if err != nil {
 		log.Printf("[ERROR] Error deleting Appstream Fleet: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
-	log.Printf("[DEBUG] %s", del)
 	return nil
 }
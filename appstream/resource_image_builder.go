@@ -1,26 +1,42 @@
 package appstream
 
 import (
+	"context"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/appstream"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/julian3xl/terraform-provider-appstream/appstream/internal/arn"
 )
 
 func resourceAppstreamImageBuilder() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceAppstreamImageBuilderCreate,
-		Read:   resourceAppstreamImageBuilderRead,
-		Update: resourceAppstreamImageBuilderUpdate,
-		Delete: resourceAppstreamImageBuilderDelete,
+		CreateContext: resourceAppstreamImageBuilderCreate,
+		ReadContext:   resourceAppstreamImageBuilderRead,
+		UpdateContext: resourceAppstreamImageBuilderUpdate,
+		DeleteContext: resourceAppstreamImageBuilderDelete,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			StateContext: importStateWithARN(arn.ResourceTypeImageBuilder),
 		},
 
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(60 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		CustomizeDiff: setTagsDiff,
+
 		Schema: map[string]*schema.Schema{
+			"arn": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -62,9 +78,10 @@ func resourceAppstreamImageBuilder() *schema.Resource {
 			},
 
 			"image_arn": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateAppstreamARN(arn.ResourceTypeImage),
 			},
 
 			"instance_type": {
@@ -72,11 +89,24 @@ func resourceAppstreamImageBuilder() *schema.Resource {
 				Required: true,
 			},
 
+			"region": regionSchema(),
+
 			"state": {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
 
+			"tags": {
+				Type:     schema.TypeMap,
+				Optional: true,
+			},
+
+			"tags_all": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
 			"vpc_config": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -97,9 +127,9 @@ func resourceAppstreamImageBuilder() *schema.Resource {
 	}
 }
 
-func resourceAppstreamImageBuilderCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceAppstreamImageBuilderCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	svc := meta.(*AWSClient).appstreamconn
+	svc := resourceAppstreamConn(d, meta)
 
 	CreateImageBuilderInputOpts := &appstream.CreateImageBuilderInput{}
 
@@ -167,66 +197,54 @@ func resourceAppstreamImageBuilderCreate(d *schema.ResourceData, meta interface{
 		CreateImageBuilderInputOpts.VpcConfig = VpcConfigConfig
 	}
 
-	log.Printf("[DEBUG] Run configuration: %s", CreateImageBuilderInputOpts)
+	if tags := meta.(*AWSClient).defaultTagsConfig.merge(expandTags(d.Get("tags").(map[string]interface{}))); len(tags) > 0 {
+		CreateImageBuilderInputOpts.Tags = aws.StringMap(tags)
+	}
 
-	resp, err := svc.CreateImageBuilder(CreateImageBuilderInputOpts)
+	_, err := svc.CreateImageBuilderWithContext(ctx, CreateImageBuilderInputOpts)
 
 	if err != nil {
 		log.Printf("[ERROR] Error creating Appstream Image Builder: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] Image builder created %s", resp)
-
-	ImageBuilderName := aws.StringValue(CreateImageBuilderInputOpts.Name)
-	for {
-
-		resp, err := svc.DescribeImageBuilders(&appstream.DescribeImageBuildersInput{
-			Names: aws.StringSlice([]string{ImageBuilderName}),
-		})
-
-		if err != nil {
-			log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
-			return err
-		}
-
-		state := resp.ImageBuilders[0].State
-		if aws.StringValue(state) == "RUNNING" {
-			break
-		}
-		if aws.StringValue(state) != "RUNNING" {
-			log.Printf("[DEBUG] Image Builder not running")
-			time.Sleep(20 * time.Second)
-			continue
-		}
+	// The image builder exists from now on, record it before waiting so
+	// that a cancelled or timed out apply does not lose track of it.
+	d.SetId(aws.StringValue(CreateImageBuilderInputOpts.Name))
 
+	if state, err := waitForImageBuilderState(ctx, svc, d.Id(), "RUNNING", d.Timeout(schema.TimeoutCreate), retryOnNotFound); err != nil {
+		log.Printf("[ERROR] %s", err)
+		d.Set("state", state)
+		return diag.FromErr(err)
 	}
 
-	d.SetId(*CreateImageBuilderInputOpts.Name)
-
-	return resourceAppstreamImageBuilderRead(d, meta)
+	return resourceAppstreamImageBuilderRead(ctx, d, meta)
 }
 
-func resourceAppstreamImageBuilderRead(d *schema.ResourceData, meta interface{}) error {
+func resourceAppstreamImageBuilderRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	svc := meta.(*AWSClient).appstreamconn
+	svc := resourceAppstreamConn(d, meta)
 
-	resp, err := svc.DescribeImageBuilders(&appstream.DescribeImageBuildersInput{})
+	resp, err := svc.DescribeImageBuildersWithContext(ctx, &appstream.DescribeImageBuildersInput{})
 	if err != nil {
 		log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
 	for _, v := range resp.ImageBuilders {
 
-		if aws.StringValue(v.Name) == d.Get("name") {
+		if aws.StringValue(v.Name) == d.Id() {
 			d.Set("name", v.Name)
 			d.Set("description", v.Description)
 			d.Set("display_name", v.DisplayName)
 			d.Set("appstream_agent_version", v.AppstreamAgentVersion)
 			d.Set("enable_default_internet_access", v.EnableDefaultInternetAccess)
 			d.Set("instance_type", v.InstanceType)
-			d.Set("image_arn", d.Get("image_arn"))
+			if _, ok := d.GetOk("image_arn"); !ok {
+				d.Set("image_arn", v.ImageArn)
+			}
+			d.Set("region", resourceRegion(d, meta))
+			d.Set("arn", v.Arn)
 			d.Set("state", v.State)
 			if v.VpcConfig != nil {
 				vpc_attr := map[string]interface{}{}
@@ -236,6 +254,20 @@ func resourceAppstreamImageBuilderRead(d *schema.ResourceData, meta interface{})
 				vpc_attr["subnet_ids"] = aws.String(strings.Join(vpc_config_sub, ","))
 				d.Set("vpc_config", vpc_attr)
 			}
+
+			tg, err := svc.ListTagsForResourceWithContext(ctx, &appstream.ListTagsForResourceInput{
+				ResourceArn: v.Arn,
+			})
+
+			if err != nil {
+				log.Printf("[ERROR] Error listing Appstream Image Builder tags: %s", err)
+				return diag.FromErr(err)
+			}
+
+			tags := flattenTags(tg.Tags, meta.(*AWSClient).ignoreTagsConfig)
+			d.Set("tags", meta.(*AWSClient).defaultTagsConfig.resourceTags(tags, d.Get("tags").(map[string]interface{})))
+			d.Set("tags_all", tags)
+
 			return nil
 		}
 	}
@@ -246,15 +278,13 @@ func resourceAppstreamImageBuilderRead(d *schema.ResourceData, meta interface{})
 }
 
 // Apstream2.0 doesn't support imageBuilder updates
-func resourceAppstreamImageBuilderUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceAppstreamImageBuilderUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	svc := meta.(*AWSClient).appstreamconn
+	svc := resourceAppstreamConn(d, meta)
 
 	StartImageBuilderInputOptions := &appstream.StartImageBuilderInput{}
 	StopImageBuilderInputOptions := &appstream.StopImageBuilderInput{}
 
-	d.Partial(true)
-
 	if v, ok := d.GetOk("name"); ok {
 		StartImageBuilderInputOptions.Name = aws.String(v.(string))
 		StopImageBuilderInputOptions.Name = aws.String(v.(string))
@@ -263,101 +293,95 @@ func resourceAppstreamImageBuilderUpdate(d *schema.ResourceData, meta interface{
 	desired_state := d.Get("state")
 
 	if d.HasChange("state") {
-		d.SetPartial("state")
+		var err error
 		if desired_state == "STOPPED" {
-			svc.StopImageBuilder(StopImageBuilderInputOptions)
+			_, err = svc.StopImageBuilderWithContext(ctx, StopImageBuilderInputOptions, retryOnStateTransition)
 		} else if desired_state == "RUNNING" {
-			svc.StartImageBuilder(StartImageBuilderInputOptions)
+			_, err = svc.StartImageBuilderWithContext(ctx, StartImageBuilderInputOptions, retryOnStateTransition)
 		}
 
-		for {
-
-			resp, err := svc.DescribeImageBuilders(&appstream.DescribeImageBuildersInput{
-				Names: aws.StringSlice([]string{*StartImageBuilderInputOptions.Name}),
-			})
+		if err != nil {
+			log.Printf("[ERROR] Error changing Appstream Image Builder state: %s", err)
+			d.Partial(true)
+			return diag.FromErr(err)
+		}
 
-			if err != nil {
-				log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
-				return err
+		if desired_state == "STOPPED" || desired_state == "RUNNING" {
+			if state, err := waitForImageBuilderState(ctx, svc, d.Id(), desired_state.(string), d.Timeout(schema.TimeoutUpdate)); err != nil {
+				log.Printf("[ERROR] %s", err)
+				d.Set("state", state)
+				return diag.FromErr(err)
 			}
+		}
+	}
 
-			curr_state := resp.ImageBuilders[0].State
-			if aws.StringValue(curr_state) == desired_state {
-				break
-			}
-			if aws.StringValue(curr_state) != desired_state {
-				time.Sleep(20 * time.Second)
-				continue
-			}
+	if d.HasChange("tags_all") {
+		o, n := d.GetChange("tags_all")
+		resp, err := svc.DescribeImageBuildersWithContext(ctx, &appstream.DescribeImageBuildersInput{
+			Names: aws.StringSlice([]string{d.Id()}),
+		})
 
+		if err != nil {
+			log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
+		}
+
+		if len(resp.ImageBuilders) == 0 {
+			d.Set("tags_all", o)
+			return diag.Errorf("Appstream Image Builder (%s) not found", d.Id())
+		}
+
+		if err := updateTags(ctx, svc, aws.StringValue(resp.ImageBuilders[0].Arn), o, n, meta.(*AWSClient).ignoreTagsConfig); err != nil {
+			log.Printf("[ERROR] Error tagging Appstream Image Builder: %s", err)
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
 		}
 	}
 
-	d.Partial(false)
-	return resourceAppstreamImageBuilderRead(d, meta)
+	return resourceAppstreamImageBuilderRead(ctx, d, meta)
 
 }
 
-func resourceAppstreamImageBuilderDelete(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamImageBuilderDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
 
 	ImageBuilderName := d.Id()
 
-	resp, err := svc.DescribeImageBuilders(&appstream.DescribeImageBuildersInput{
+	resp, err := svc.DescribeImageBuildersWithContext(ctx, &appstream.DescribeImageBuildersInput{
 		Names: aws.StringSlice([]string{ImageBuilderName}),
 	})
 
 	if err != nil {
 		log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
 	state := resp.ImageBuilders[0].State
 
 	if aws.StringValue(state) == "RUNNING" {
-		resp, err := svc.StopImageBuilder(&appstream.StopImageBuilderInput{
+		_, err := svc.StopImageBuilderWithContext(ctx, &appstream.StopImageBuilderInput{
 			Name: aws.String(d.Id()),
-		})
+		}, retryOnStateTransition)
 
 		if err != nil {
 			log.Printf("[ERROR] Error stopping Appstream Image Builder: %s", err)
-			return err
+			return diag.FromErr(err)
 		}
 
-		log.Printf("[DEBUG] %s", resp)
-
-		for {
-
-			resp, err := svc.DescribeImageBuilders(&appstream.DescribeImageBuildersInput{
-				Names: aws.StringSlice([]string{ImageBuilderName}),
-			})
-
-			if err != nil {
-				log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
-				return err
-			}
-
-			state := resp.ImageBuilders[0].State
-			if aws.StringValue(state) == "STOPPED" {
-				break
-			}
-			if aws.StringValue(state) != "STOPPED" {
-				log.Printf("[DEBUG] Image Builder not running")
-				time.Sleep(20 * time.Second)
-				continue
-			}
-
+		if _, err := waitForImageBuilderState(ctx, svc, ImageBuilderName, "STOPPED", d.Timeout(schema.TimeoutDelete)); err != nil {
+			log.Printf("[ERROR] %s", err)
+			return diag.FromErr(err)
 		}
 	}
 
-	del, err := svc.DeleteImageBuilder(&appstream.DeleteImageBuilderInput{
+	_, err = svc.DeleteImageBuilderWithContext(ctx, &appstream.DeleteImageBuilderInput{
 		Name: aws.String(d.Id()),
-	})
+	}, retryOnStateTransition)
 	if err != nil {
 		log.Printf("[ERROR] Error deleting Appstream Image Builder: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
-	log.Printf("[DEBUG] %s", del)
 
 	return nil
 }
@@ -1,25 +1,34 @@
 package appstream
 
 import (
+	"context"
 	"log"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/appstream"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/julian3xl/terraform-provider-appstream/appstream/internal/arn"
 )
 
 func resourceAppstreamStack() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceAppstreamStackCreate,
-		Read:   resourceAppstreamStackRead,
-		Update: resourceAppstreamStackUpdate,
-		Delete: resourceAppstreamStackDelete,
+		CreateContext: resourceAppstreamStackCreate,
+		ReadContext:   resourceAppstreamStackRead,
+		UpdateContext: resourceAppstreamStackUpdate,
+		DeleteContext: resourceAppstreamStackDelete,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			StateContext: importStateWithARN(arn.ResourceTypeStack),
 		},
 
+		CustomizeDiff: setTagsDiff,
+
 		Schema: map[string]*schema.Schema{
+			"arn": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"access_endpoints": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -87,6 +96,8 @@ func resourceAppstreamStack() *schema.Resource {
 				Optional: true,
 			},
 
+			"region": regionSchema(),
+
 			"storage_connectors": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -116,6 +127,12 @@ func resourceAppstreamStack() *schema.Resource {
 				Optional: true,
 			},
 
+			"tags_all": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
 			"user_settings": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -136,8 +153,8 @@ func resourceAppstreamStack() *schema.Resource {
 	}
 }
 
-func resourceAppstreamStackCreate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
 	CreateStackInputOpts := &appstream.CreateStackInput{}
 
 	if v, ok := d.GetOk("access_endpoints"); ok {
@@ -180,62 +197,32 @@ func resourceAppstreamStackCreate(d *schema.ResourceData, meta interface{}) erro
 		CreateStackInputOpts.UserSettings = expandUserSettingConfigs(v.(*schema.Set).List())
 	}
 
-	log.Printf("[DEBUG] Run configuration: %s", CreateStackInputOpts)
+	if tags := meta.(*AWSClient).defaultTagsConfig.merge(expandTags(d.Get("tags").(map[string]interface{}))); len(tags) > 0 {
+		CreateStackInputOpts.Tags = aws.StringMap(tags)
+	}
 
-	resp, err := svc.CreateStack(CreateStackInputOpts)
+	_, err := svc.CreateStackWithContext(ctx, CreateStackInputOpts)
 	if err != nil {
 		log.Printf("[ERROR] Error creating Appstream Stack: %s", err)
-		return err
-	}
-
-	log.Printf("[DEBUG] Appstream Stack created %s ", resp)
-
-	if v, ok := d.GetOk("tags"); ok {
-		time.Sleep(2 * time.Second)
-
-		stack_name := aws.StringValue(CreateStackInputOpts.Name)
-		get, err := svc.DescribeStacks(&appstream.DescribeStacksInput{
-			Names: aws.StringSlice([]string{stack_name}),
-		})
-
-		if err != nil {
-			log.Printf("[ERROR] Error describing Appstream Stack: %s", err)
-			return err
-		}
-
-		if get.Stacks == nil {
-			log.Printf("[DEBUG] Appstream Stack (%s) not found", d.Id())
-		}
-
-		tag, err := svc.TagResource(&appstream.TagResourceInput{
-			ResourceArn: get.Stacks[0].Arn,
-			Tags:        aws.StringMap(expandTags(v.(map[string]interface{}))),
-		})
-
-		if err != nil {
-			log.Printf("[ERROR] Error tagging Appstream Stack: %s", err)
-			return err
-		}
-
-		log.Printf("[DEBUG] %s", tag)
+		return diag.FromErr(err)
 	}
 
 	d.SetId(*CreateStackInputOpts.Name)
 
-	return resourceAppstreamStackRead(d, meta)
+	return resourceAppstreamStackRead(ctx, d, meta)
 }
 
-func resourceAppstreamStackRead(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
 
-	resp, err := svc.DescribeStacks(&appstream.DescribeStacksInput{})
+	resp, err := svc.DescribeStacksWithContext(ctx, &appstream.DescribeStacksInput{})
 	if err != nil {
 		log.Printf("[ERROR] Error describing stacks: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
 	for _, v := range resp.Stacks {
-		if aws.StringValue(v.Name) == d.Get("name") {
+		if aws.StringValue(v.Name) == d.Id() {
 			ae_res := make([]map[string]interface{}, 0)
 			for _, raw := range v.AccessEndpoints {
 				ae_attr := map[string]interface{}{}
@@ -264,6 +251,8 @@ func resourceAppstreamStackRead(d *schema.ResourceData, meta interface{}) error
 			d.Set("feedback_url", v.FeedbackURL)
 			d.Set("name", v.Name)
 			d.Set("redirect_url", v.RedirectURL)
+			d.Set("region", resourceRegion(d, meta))
+			d.Set("arn", v.Arn)
 
 			sc_res := make([]map[string]interface{}, 0)
 			for _, raw := range v.StorageConnectors {
@@ -280,13 +269,13 @@ func resourceAppstreamStackRead(d *schema.ResourceData, meta interface{}) error
 				}
 			}
 
-			tg, err := svc.ListTagsForResource(&appstream.ListTagsForResourceInput{
+			tg, err := svc.ListTagsForResourceWithContext(ctx, &appstream.ListTagsForResourceInput{
 				ResourceArn: v.Arn,
 			})
 
 			if err != nil {
 				log.Printf("[ERROR] Error listing stack tags: %s", err)
-				return err
+				return diag.FromErr(err)
 			}
 
 			if tg.Tags == nil {
@@ -294,15 +283,9 @@ func resourceAppstreamStackRead(d *schema.ResourceData, meta interface{}) error
 				return nil
 			}
 
-			if len(tg.Tags) > 0 {
-				tags_attr := make(map[string]string)
-				tags := tg.Tags
-				for k, v := range tags {
-					tags_attr[k] = aws.StringValue(v)
-				}
-
-				d.Set("tags", tags_attr)
-			}
+			tags := flattenTags(tg.Tags, meta.(*AWSClient).ignoreTagsConfig)
+			d.Set("tags", meta.(*AWSClient).defaultTagsConfig.resourceTags(tags, d.Get("tags").(map[string]interface{})))
+			d.Set("tags_all", tags)
 
 			us_res := make([]map[string]interface{}, 0)
 			for _, raw := range v.UserSettings {
@@ -327,49 +310,41 @@ func resourceAppstreamStackRead(d *schema.ResourceData, meta interface{}) error
 	return nil
 }
 
-func resourceAppstreamStackUpdate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
 	UpdateStackInputOpts := &appstream.UpdateStackInput{}
 
-	d.Partial(true)
-
 	if d.HasChange("access_endpoints") {
-		d.SetPartial("access_endpoints")
 		log.Printf("[DEBUG] Modify appstream stack")
 		access_endpoints := d.Get("access_endpoints").(*schema.Set).List()
 		UpdateStackInputOpts.AccessEndpoints = expandAccessEndpointsConfigs(access_endpoints)
 	}
 
 	if d.HasChange("application_settings") {
-		d.SetPartial("application_settings")
 		log.Printf("[DEBUG] Modify appstream stack")
 		application_settings := d.Get("application_settings").([]interface{})
 		UpdateStackInputOpts.ApplicationSettings = expandApplicationSettings(application_settings)
 	}
 
 	if d.HasChange("description") {
-		d.SetPartial("description")
 		log.Printf("[DEBUG] Modify appstream stack")
 		description := d.Get("description").(string)
 		UpdateStackInputOpts.Description = aws.String(description)
 	}
 
 	if d.HasChange("display_name") {
-		d.SetPartial("display_name")
 		log.Printf("[DEBUG] Modify appstream stack")
 		displayname := d.Get("display_name").(string)
 		UpdateStackInputOpts.DisplayName = aws.String(displayname)
 	}
 
 	if d.HasChange("embed_host_domains") {
-		d.SetPartial("embed_host_domains")
 		log.Printf("[DEBUG] Modify appstream stack")
 		embed_host_domains := d.Get("embed_host_domains").(*schema.Set)
 		UpdateStackInputOpts.EmbedHostDomains = expandStringSet(embed_host_domains)
 	}
 
 	if d.HasChange("feedback_url") {
-		d.SetPartial("feedback_url")
 		log.Printf("[DEBUG] Modify appstream stack")
 		feedbackurl := d.Get("feedback_url").(string)
 		UpdateStackInputOpts.FeedbackURL = aws.String(feedbackurl)
@@ -380,81 +355,68 @@ func resourceAppstreamStackUpdate(d *schema.ResourceData, meta interface{}) erro
 	}
 
 	if d.HasChange("redirect_url") {
-		d.SetPartial("redirect_url")
 		log.Printf("[DEBUG] Modify appstream stack")
 		redirecturl := d.Get("redirect_url").(string)
 		UpdateStackInputOpts.RedirectURL = aws.String(redirecturl)
 	}
 
 	if d.HasChange("storage_connectors") {
-		d.SetPartial("storage_connectors")
 		log.Printf("[DEBUG] Modify appstream stack")
 		storage_connectors := d.Get("storage_connectors").(*schema.Set).List()
 		UpdateStackInputOpts.StorageConnectors = expandStorageConnectorConfigs(storage_connectors)
 	}
 
 	if d.HasChange("user_settings") {
-		d.SetPartial("user_settings")
 		log.Printf("[DEBUG] Modify appstream stack")
 		user_settings := d.Get("user_settings").(*schema.Set).List()
 		UpdateStackInputOpts.UserSettings = expandUserSettingConfigs(user_settings)
 	}
 
-	resp, err := svc.UpdateStack(UpdateStackInputOpts)
+	_, err := svc.UpdateStackWithContext(ctx, UpdateStackInputOpts)
 	if err != nil {
 		log.Printf("[ERROR] Error updating Appstream Stack: %s", err)
-		return err
+		d.Partial(true)
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] Appstream Stack updated %s ", resp)
-
-	if v, ok := d.GetOk("tags"); ok && d.HasChange("tags") {
-		time.Sleep(2 * time.Second)
-
+	if d.HasChange("tags_all") {
+		o, n := d.GetChange("tags_all")
 		stack_name := aws.StringValue(UpdateStackInputOpts.Name)
-		get, err := svc.DescribeStacks(&appstream.DescribeStacksInput{
+		get, err := svc.DescribeStacksWithContext(ctx, &appstream.DescribeStacksInput{
 			Names: aws.StringSlice([]string{stack_name}),
 		})
 
 		if err != nil {
 			log.Printf("[ERROR] Error describing Appstream Stack: %s", err)
-			return err
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
 		}
 
-		if get.Stacks == nil {
-			log.Printf("[DEBUG] Appstream Stack (%s) not found", d.Id())
+		if len(get.Stacks) == 0 {
+			d.Set("tags_all", o)
+			return diag.Errorf("Appstream Stack (%s) not found", d.Id())
 		}
 
-		tag, err := svc.TagResource(&appstream.TagResourceInput{
-			ResourceArn: get.Stacks[0].Arn,
-			Tags:        aws.StringMap(expandTags(v.(map[string]interface{}))),
-		})
-
-		if err != nil {
+		if err := updateTags(ctx, svc, aws.StringValue(get.Stacks[0].Arn), o, n, meta.(*AWSClient).ignoreTagsConfig); err != nil {
 			log.Printf("[ERROR] Error tagging Appstream Stack: %s", err)
-			return err
+			d.Set("tags_all", o)
+			return diag.FromErr(err)
 		}
-
-		log.Printf("[DEBUG] %s", tag)
 	}
 
-	d.Partial(false)
-
 	return nil
 }
 
-func resourceAppstreamStackDelete(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
-	resp, err := svc.DeleteStack(&appstream.DeleteStackInput{
+func resourceAppstreamStackDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
+	_, err := svc.DeleteStackWithContext(ctx, &appstream.DeleteStackInput{
 		Name: aws.String(d.Id()),
 	})
 
 	if err != nil {
 		log.Printf("[ERROR] Error deleting Appstream Stack: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] %s", resp)
-
 	return nil
 }
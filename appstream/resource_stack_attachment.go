@@ -1,23 +1,33 @@
 package appstream
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/appstream"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourceAppstreamStackAttachment() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceAppstreamStackAttachmentCreate,
-		Read:   resourceAppstreamStackAttachmentRead,
-		Update: resourceAppstreamStackAttachmentUpdate,
-		Delete: resourceAppstreamStackAttachmentDelete,
+		CreateContext: resourceAppstreamStackAttachmentCreate,
+		ReadContext:   resourceAppstreamStackAttachmentRead,
+		UpdateContext: resourceAppstreamStackAttachmentUpdate,
+		DeleteContext: resourceAppstreamStackAttachmentDelete,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			StateContext: importStateWithRegion,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
 		},
 
 		Schema: map[string]*schema.Schema{
@@ -30,12 +40,19 @@ func resourceAppstreamStackAttachment() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+
+			"region": regionSchema(),
 		},
 	}
 }
 
-func resourceAppstreamStackAttachmentCreate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackAttachmentCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	// Associations are retried while the fleet or the stack is not yet
+	// visible, bound by the create timeout.
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout(schema.TimeoutCreate))
+	defer cancel()
+
+	svc := resourceAppstreamConn(d, meta)
 	AssociateFleetInputOpts := &appstream.AssociateFleetInput{}
 
 	if stack, ok := d.GetOk("appstream_stack_id"); ok {
@@ -46,39 +63,36 @@ func resourceAppstreamStackAttachmentCreate(d *schema.ResourceData, meta interfa
 		AssociateFleetInputOpts.FleetName = aws.String(fleet.(string))
 	}
 
-	log.Printf("[DEBUG] Run configuration: %s", AssociateFleetInputOpts)
-
-	resp, err := svc.AssociateFleet(AssociateFleetInputOpts)
+	_, err := svc.AssociateFleetWithContext(ctx, AssociateFleetInputOpts, retryOnNotFound, retryOnStateTransition)
 	if err != nil {
 		log.Printf("[ERROR] Error associating Appstream Fleet to Stack: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] Appstream Fleet associated to Stack %s ", resp)
-
 	d.SetId(fmt.Sprintf("%s_%s", *AssociateFleetInputOpts.StackName, *AssociateFleetInputOpts.FleetName))
 
-	return resourceAppstreamStackAttachmentRead(d, meta)
+	return resourceAppstreamStackAttachmentRead(ctx, d, meta)
 }
 
-func resourceAppstreamStackAttachmentRead(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackAttachmentRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	svc := resourceAppstreamConn(d, meta)
 
 	AssociationId := strings.Split(d.Id(), "_")
 
-	resp, err := svc.ListAssociatedFleets(&appstream.ListAssociatedFleetsInput{
-		StackName: &AssociationId[0],
-	})
-
+	fleets, err := listStackAssociatedFleets(ctx, svc, AssociationId[0])
 	if err != nil {
-		log.Printf("[ERROR] Error describing associations: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
 	stack := AssociationId[0]
-	for _, fleet := range resp.Names {
+	for _, fleet := range fleets {
+		if fleet != AssociationId[1] {
+			continue
+		}
+
 		d.Set("appstream_stack_id", stack)
 		d.Set("appstream_fleet_id", fleet)
+		d.Set("region", resourceRegion(d, meta))
 
 		return nil
 	}
@@ -88,8 +102,11 @@ func resourceAppstreamStackAttachmentRead(d *schema.ResourceData, meta interface
 	return nil
 }
 
-func resourceAppstreamStackAttachmentUpdate(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackAttachmentUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout(schema.TimeoutUpdate))
+	defer cancel()
+
+	svc := resourceAppstreamConn(d, meta)
 	DisassociateFleetInputOpts := &appstream.DisassociateFleetInput{}
 	AssociateFleetInputOpts := &appstream.AssociateFleetInput{}
 
@@ -100,67 +117,102 @@ func resourceAppstreamStackAttachmentUpdate(d *schema.ResourceData, meta interfa
 	AssociateFleetInputOpts.StackName = DisassociateFleetInputOpts.StackName
 	AssociateFleetInputOpts.FleetName = DisassociateFleetInputOpts.FleetName
 
-	d.Partial(true)
-
 	if d.HasChange("appstream_stack_id") {
-		d.SetPartial("appstream_stack_id")
 		log.Printf("[DEBUG] Modify appstream association")
 		appstream_stack_id := d.Get("appstream_stack_id").(string)
 		AssociateFleetInputOpts.StackName = aws.String(appstream_stack_id)
 	}
 
 	if d.HasChange("appstream_fleet_id") {
-		d.SetPartial("appstream_fleet_id")
 		log.Printf("[DEBUG] Modify appstream association")
 		appstream_fleet_id := d.Get("appstream_fleet_id").(string)
 		AssociateFleetInputOpts.FleetName = aws.String(appstream_fleet_id)
 	}
 
 	if d.HasChanges("appstream_stack_id", "appstream_fleet_id") {
-		dis_resp, dis_err := svc.DisassociateFleet(&appstream.DisassociateFleetInput{
-			StackName: aws.String(*DisassociateFleetInputOpts.StackName),
-			FleetName: aws.String(*DisassociateFleetInputOpts.FleetName),
-		})
-
-		if dis_err != nil {
-			log.Printf("[ERROR] Error disassociating Appstream Fleet from Stack: %s", dis_err)
-			return dis_err
+		// A blue/green image rollout of the fleet already moved the
+		// association to the new fleet and deleted the old one.
+		dis_fleets, err := listStackAssociatedFleets(ctx, svc, *DisassociateFleetInputOpts.StackName)
+		if err != nil {
+			return diag.FromErr(err)
 		}
 
-		log.Printf("[DEBUG] %s", dis_resp)
+		if slices.Contains(dis_fleets, *DisassociateFleetInputOpts.FleetName) {
+			_, dis_err := svc.DisassociateFleetWithContext(ctx, &appstream.DisassociateFleetInput{
+				StackName: aws.String(*DisassociateFleetInputOpts.StackName),
+				FleetName: aws.String(*DisassociateFleetInputOpts.FleetName),
+			}, retryOnStateTransition)
 
-		ass_resp, ass_err := svc.AssociateFleet(AssociateFleetInputOpts)
-		if ass_err != nil {
-			log.Printf("[ERROR] Error associating Appstream Fleet to Stack: %s", ass_err)
-			return ass_err
+			if dis_err != nil {
+				log.Printf("[ERROR] Error disassociating Appstream Fleet from Stack: %s", dis_err)
+				return diag.FromErr(dis_err)
+			}
 		}
 
-		d.SetId(fmt.Sprintf("%s_%s", *AssociateFleetInputOpts.StackName, *AssociateFleetInputOpts.FleetName))
+		ass_fleets, err := listStackAssociatedFleets(ctx, svc, *AssociateFleetInputOpts.StackName)
+		if err != nil {
+			d.Partial(true)
+			return diag.FromErr(err)
+		}
 
-		log.Printf("[DEBUG] Appstream Fleet associated to Stack %s ", ass_resp)
-	}
+		if !slices.Contains(ass_fleets, *AssociateFleetInputOpts.FleetName) {
+			_, ass_err := svc.AssociateFleetWithContext(ctx, AssociateFleetInputOpts, retryOnStateTransition)
+			if ass_err != nil {
+				log.Printf("[ERROR] Error associating Appstream Fleet to Stack: %s", ass_err)
+				d.Partial(true)
+				return diag.FromErr(ass_err)
+			}
+		}
 
-	d.Partial(false)
+		d.SetId(fmt.Sprintf("%s_%s", *AssociateFleetInputOpts.StackName, *AssociateFleetInputOpts.FleetName))
+	}
 
 	return nil
 }
 
-func resourceAppstreamStackAttachmentDelete(d *schema.ResourceData, meta interface{}) error {
-	svc := meta.(*AWSClient).appstreamconn
+func resourceAppstreamStackAttachmentDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout(schema.TimeoutDelete))
+	defer cancel()
+
+	svc := resourceAppstreamConn(d, meta)
 
 	AssociationId := strings.Split(d.Id(), "_")
 
-	resp, err := svc.DisassociateFleet(&appstream.DisassociateFleetInput{
+	_, err := svc.DisassociateFleetWithContext(ctx, &appstream.DisassociateFleetInput{
 		StackName: aws.String(AssociationId[0]),
 		FleetName: aws.String(AssociationId[1]),
-	})
+	}, retryOnStateTransition)
 
 	if err != nil {
 		log.Printf("[ERROR] Error disassociating Appstream Fleet from Stack: %s", err)
-		return err
+		return diag.FromErr(err)
 	}
 
-	log.Printf("[DEBUG] %s", resp)
-
 	return nil
 }
+
+// listStackAssociatedFleets returns the names of the fleets associated to
+// the stack.
+func listStackAssociatedFleets(ctx context.Context, svc *appstream.AppStream, name string) ([]string, error) {
+	var fleets []string
+
+	input := &appstream.ListAssociatedFleetsInput{
+		StackName: aws.String(name),
+	}
+
+	for {
+		resp, err := svc.ListAssociatedFleetsWithContext(ctx, input)
+		if err != nil {
+			log.Printf("[ERROR] Error describing associations: %s", err)
+			return nil, err
+		}
+
+		fleets = append(fleets, aws.StringValueSlice(resp.Names)...)
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return fleets, nil
+		}
+
+		input.NextToken = resp.NextToken
+	}
+}
@@ -0,0 +1,145 @@
+package appstream
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/appstream"
+)
+
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+
+	retryMinDelay = 1 * time.Second
+	retryMaxDelay = 30 * time.Second
+)
+
+// appstreamRetryer retries throttling and the transient errors AppStream
+// returns while a resource is changing state, using exponential backoff
+// with full jitter.
+type appstreamRetryer struct {
+	NumMaxRetries int
+
+	// retryNotFound is only set for calls that follow a create, when the
+	// new resource may not be visible yet.
+	retryNotFound bool
+
+	// retryNotPermitted is only set for calls that act on a resource which
+	// may still be changing state, e.g. starting a fleet that is stopping.
+	retryNotPermitted bool
+
+	// throttle is shared by every request of the client in adaptive mode.
+	throttle *adaptiveThrottle
+}
+
+func newAppstreamRetryer(maxRetries int, mode string) appstreamRetryer {
+	retryer := appstreamRetryer{
+		NumMaxRetries: maxRetries,
+	}
+
+	if mode == retryModeAdaptive {
+		retryer.throttle = &adaptiveThrottle{}
+	}
+
+	return retryer
+}
+
+func (r appstreamRetryer) MaxRetries() int {
+	return r.NumMaxRetries
+}
+
+func (r appstreamRetryer) ShouldRetry(req *request.Request) bool {
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+
+	if req.IsErrorRetryable() || req.IsErrorThrottle() {
+		return true
+	}
+
+	if err, ok := req.Error.(awserr.Error); ok {
+		switch err.Code() {
+		case appstream.ErrCodeConcurrentModificationException,
+			appstream.ErrCodeRequestLimitExceededException:
+			return true
+		case appstream.ErrCodeOperationNotPermittedException:
+			return r.retryNotPermitted
+		case appstream.ErrCodeResourceNotFoundException:
+			return r.retryNotFound
+		}
+	}
+
+	return false
+}
+
+func (r appstreamRetryer) RetryRules(req *request.Request) time.Duration {
+	delay := retryMaxDelay
+	if req.RetryCount < 16 {
+		delay = time.Duration(math.Min(float64(retryMinDelay<<uint(req.RetryCount)), float64(retryMaxDelay)))
+	}
+
+	delay = time.Duration(rand.Int63n(int64(delay)))
+	if delay < retryMinDelay {
+		delay = retryMinDelay
+	}
+
+	if r.throttle != nil && req.IsErrorThrottle() {
+		r.throttle.backoff(delay)
+	}
+
+	return delay
+}
+
+// adaptiveThrottle holds back every request of a client once one of them
+// has been throttled, instead of only delaying the request that failed.
+type adaptiveThrottle struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func (t *adaptiveThrottle) backoff(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(t.until) {
+		t.until = until
+	}
+}
+
+func (t *adaptiveThrottle) wait(req *request.Request) {
+	t.mu.Lock()
+	until := t.until
+	t.mu.Unlock()
+
+	if wait := time.Until(until); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+		}
+	}
+}
+
+// retryOnNotFound is a request option for calls made right after a create,
+// while AppStream may still answer ResourceNotFoundException for the new
+// resource.
+func retryOnNotFound(req *request.Request) {
+	if retryer, ok := req.Retryer.(appstreamRetryer); ok {
+		retryer.retryNotFound = true
+		req.Retryer = retryer
+	}
+}
+
+// retryOnStateTransition is a request option for calls that start, stop,
+// delete or associate a resource, which AppStream rejects with
+// OperationNotPermittedException until the resource is done changing state.
+func retryOnStateTransition(req *request.Request) {
+	if retryer, ok := req.Retryer.(appstreamRetryer); ok {
+		retryer.retryNotPermitted = true
+		req.Retryer = retryer
+	}
+}
@@ -0,0 +1,161 @@
+package appstream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/appstream"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// ignoreTagsConfig holds the tag keys and key prefixes configured in the
+// provider ignore_tags block. Matching tags are managed outside Terraform.
+type ignoreTagsConfig struct {
+	keys        []string
+	keyPrefixes []string
+}
+
+// ignored reports whether the tag key matches the ignore_tags configuration.
+func (c *ignoreTagsConfig) ignored(key string) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, k := range c.keys {
+		if key == k {
+			return true
+		}
+	}
+
+	for _, prefix := range c.keyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flattenTags converts the tags returned by ListTagsForResource, dropping
+// the keys ignored by the provider configuration.
+func flattenTags(tags map[string]*string, ignoreConfig *ignoreTagsConfig) map[string]string {
+	attr := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if ignoreConfig.ignored(k) {
+			continue
+		}
+
+		attr[k] = aws.StringValue(v)
+	}
+
+	return attr
+}
+
+// defaultTagsConfig holds the tags configured in the provider default_tags
+// block, applied to every taggable resource.
+type defaultTagsConfig struct {
+	tags map[string]string
+}
+
+// merge returns the default tags overridden by the resource tags.
+func (c *defaultTagsConfig) merge(resourceTags map[string]string) map[string]string {
+	allTags := make(map[string]string)
+	if c != nil {
+		for k, v := range c.tags {
+			allTags[k] = v
+		}
+	}
+
+	for k, v := range resourceTags {
+		allTags[k] = v
+	}
+
+	return allTags
+}
+
+// resourceTags strips the default tags from the tags read back from a
+// resource, unless the key is also set in the resource configuration.
+func (c *defaultTagsConfig) resourceTags(allTags map[string]string, configured map[string]interface{}) map[string]string {
+	tags := make(map[string]string, len(allTags))
+	for k, v := range allTags {
+		if _, ok := configured[k]; !ok && c != nil {
+			if defaultValue, ok := c.tags[k]; ok && defaultValue == v {
+				continue
+			}
+		}
+
+		tags[k] = v
+	}
+
+	return tags
+}
+
+// setTagsDiff plans tags_all as the merge of the provider default tags and
+// the resource tags, so drift is detected against the effective tag set.
+func setTagsDiff(ctx context.Context, diff *schema.ResourceDiff, meta interface{}) error {
+	if !diff.NewValueKnown("tags") {
+		return diff.SetNewComputed("tags_all")
+	}
+
+	allTags := meta.(*AWSClient).defaultTagsConfig.merge(expandTags(diff.Get("tags").(map[string]interface{})))
+
+	if diff.HasChange("tags") || !reflect.DeepEqual(expandTags(diff.Get("tags_all").(map[string]interface{})), allTags) {
+		return diff.SetNew("tags_all", allTags)
+	}
+
+	return nil
+}
+
+// updateTags applies the difference between the old and new tag maps to an
+// AppStream resource: removed keys are untagged and added or changed keys
+// are tagged. Keys matching ignore_tags are never removed.
+func updateTags(ctx context.Context, conn *appstream.AppStream, arn string, oldTagsRaw, newTagsRaw interface{}, ignoreConfig *ignoreTagsConfig) error {
+	oldTags := expandTags(oldTagsRaw.(map[string]interface{}))
+	newTags := expandTags(newTagsRaw.(map[string]interface{}))
+
+	removedKeys := make([]string, 0)
+	for k := range oldTags {
+		if _, ok := newTags[k]; !ok && !ignoreConfig.ignored(k) {
+			removedKeys = append(removedKeys, k)
+		}
+	}
+
+	if len(removedKeys) > 0 {
+		log.Printf("[DEBUG] Untagging Appstream resource (%s): %s", arn, removedKeys)
+
+		_, err := conn.UntagResourceWithContext(ctx, &appstream.UntagResourceInput{
+			ResourceArn: aws.String(arn),
+			TagKeys:     aws.StringSlice(removedKeys),
+		})
+
+		if err != nil {
+			return fmt.Errorf("error untagging Appstream resource (%s): %s", arn, err)
+		}
+	}
+
+	updatedTags := make(map[string]string)
+	for k, v := range newTags {
+		if oldValue, ok := oldTags[k]; !ok || oldValue != v {
+			updatedTags[k] = v
+		}
+	}
+
+	if len(updatedTags) > 0 {
+		log.Printf("[DEBUG] Tagging Appstream resource (%s): %s", arn, updatedTags)
+
+		_, err := conn.TagResourceWithContext(ctx, &appstream.TagResourceInput{
+			ResourceArn: aws.String(arn),
+			Tags:        aws.StringMap(updatedTags),
+		})
+
+		if err != nil {
+			return fmt.Errorf("error tagging Appstream resource (%s): %s", arn, err)
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,9 @@
 package appstream
 
 import (
-	"log"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/appstream"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func expandStringList(configured []interface{}) []*string {
@@ -67,7 +65,6 @@ func expandStorageConnectorConfigs(storageConnectorConfigs []interface{}) []*app
 			config.ConnectorType = aws.String(v.(string))
 		}
 
-		log.Printf("[***] %s", configAttributes["domains"])
 		if v, ok := configAttributes["domains"]; ok && len(v.([]interface{})) > 0 {
 			config.Domains = v.([]*string)
 		}
@@ -133,6 +130,21 @@ func expandVpcConfigs(vpcConfigs []interface{}) *appstream.VpcConfig {
 	return VpcConfigConfig
 }
 
+func expandDomainJoinInfo(domainInfos []interface{}) *appstream.DomainJoinInfo {
+	DomainJoinInfoConfig := &appstream.DomainJoinInfo{}
+	attr := domainInfos[0].(map[string]interface{})
+
+	if v, ok := attr["directory_name"]; ok {
+		DomainJoinInfoConfig.DirectoryName = aws.String(v.(string))
+	}
+
+	if v, ok := attr["organizational_unit_distinguished_name"]; ok {
+		DomainJoinInfoConfig.OrganizationalUnitDistinguishedName = aws.String(v.(string))
+	}
+
+	return DomainJoinInfoConfig
+}
+
 func expandTags(data_tags map[string]interface{}) map[string]string {
 	attr := make(map[string]string)
 	for k, v := range data_tags {
@@ -141,3 +153,56 @@ func expandTags(data_tags map[string]interface{}) map[string]string {
 
 	return attr
 }
+
+func flattenVpcConfig(vpcConfig *appstream.VpcConfig) []interface{} {
+	if vpcConfig == nil {
+		return []interface{}{}
+	}
+
+	attr := map[string]interface{}{
+		"security_group_ids": flattenStringSet(vpcConfig.SecurityGroupIds),
+		"subnet_ids":         flattenStringSet(vpcConfig.SubnetIds),
+	}
+
+	return []interface{}{attr}
+}
+
+func flattenDomainJoinInfo(domainJoinInfo *appstream.DomainJoinInfo) []interface{} {
+	if domainJoinInfo == nil {
+		return []interface{}{}
+	}
+
+	attr := map[string]interface{}{
+		"directory_name":                         aws.StringValue(domainJoinInfo.DirectoryName),
+		"organizational_unit_distinguished_name": aws.StringValue(domainJoinInfo.OrganizationalUnitDistinguishedName),
+	}
+
+	return []interface{}{attr}
+}
+
+func flattenComputeCapacityStatus(computeCapacityStatus *appstream.ComputeCapacityStatus) []interface{} {
+	if computeCapacityStatus == nil {
+		return []interface{}{}
+	}
+
+	attr := map[string]interface{}{
+		"available": int(aws.Int64Value(computeCapacityStatus.Available)),
+		"desired":   int(aws.Int64Value(computeCapacityStatus.Desired)),
+		"in_use":    int(aws.Int64Value(computeCapacityStatus.InUse)),
+		"running":   int(aws.Int64Value(computeCapacityStatus.Running)),
+	}
+
+	return []interface{}{attr}
+}
+
+func flattenFleetErrors(fleetErrors []*appstream.FleetError) []interface{} {
+	errors := make([]interface{}, 0, len(fleetErrors))
+	for _, fleetError := range fleetErrors {
+		errors = append(errors, map[string]interface{}{
+			"error_code":    aws.StringValue(fleetError.ErrorCode),
+			"error_message": aws.StringValue(fleetError.ErrorMessage),
+		})
+	}
+
+	return errors
+}
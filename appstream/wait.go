@@ -0,0 +1,127 @@
+package appstream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/appstream"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+)
+
+// statePollInterval is the interval between two state checks while waiting
+// for a fleet or an image builder.
+const statePollInterval = 20 * time.Second
+
+// fleetPendingStates maps the target states of a fleet to the transition
+// state leading to them.
+var fleetPendingStates = map[string]string{
+	appstream.FleetStateRunning: appstream.FleetStateStarting,
+	appstream.FleetStateStopped: appstream.FleetStateStopping,
+}
+
+// waitForFleetState polls the fleet until it reaches the target state, the
+// timeout elapses or the context is cancelled. The wait fails as soon as the
+// fleet leaves the transition to the target state, e.g. a fleet that stops
+// instead of starting, with the fleet errors it reports. It returns the last
+// observed state, so that callers can record it when the wait fails.
+func waitForFleetState(ctx context.Context, svc *appstream.AppStream, name, target string, timeout time.Duration, opts ...request.Option) (string, error) {
+	var lastState string
+
+	stateConf := &retry.StateChangeConf{
+		Pending:      []string{fleetPendingStates[target]},
+		Target:       []string{target},
+		Timeout:      timeout,
+		PollInterval: statePollInterval,
+		Refresh: func() (interface{}, string, error) {
+			resp, err := svc.DescribeFleetsWithContext(ctx, &appstream.DescribeFleetsInput{
+				Names: aws.StringSlice([]string{name}),
+			}, opts...)
+
+			if err != nil {
+				log.Printf("[ERROR] Error describing Appstream Fleet: %s", err)
+				return nil, "", err
+			}
+
+			if len(resp.Fleets) == 0 {
+				return nil, "", nil
+			}
+
+			lastState = aws.StringValue(resp.Fleets[0].State)
+			log.Printf("[DEBUG] Appstream Fleet (%s) state: %s", name, lastState)
+
+			if fleetErrors := resp.Fleets[0].FleetErrors; lastState != target && lastState != fleetPendingStates[target] && len(fleetErrors) > 0 {
+				return nil, "", fmt.Errorf("Appstream Fleet (%s) is %s and reported errors: %s", name, lastState, flattenFleetErrorMessages(fleetErrors))
+			}
+
+			return resp.Fleets[0], lastState, nil
+		},
+	}
+
+	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+		return lastState, fmt.Errorf("error waiting for Appstream Fleet (%s) to become %s: %w", name, target, err)
+	}
+
+	return lastState, nil
+}
+
+// waitForImageBuilderState polls the image builder until it reaches the
+// target state, the timeout elapses or the context is cancelled. It returns
+// the last observed state, so that callers can record it when the wait fails.
+func waitForImageBuilderState(ctx context.Context, svc *appstream.AppStream, name, target string, timeout time.Duration, opts ...request.Option) (string, error) {
+	var lastState string
+
+	pending := make([]string, 0)
+	for _, state := range otherStates(appstream.ImageBuilderState_Values(), target) {
+		if state != appstream.ImageBuilderStateFailed && state != appstream.ImageBuilderStateDeleting {
+			pending = append(pending, state)
+		}
+	}
+
+	stateConf := &retry.StateChangeConf{
+		Pending:      pending,
+		Target:       []string{target},
+		Timeout:      timeout,
+		PollInterval: statePollInterval,
+		Refresh: func() (interface{}, string, error) {
+			resp, err := svc.DescribeImageBuildersWithContext(ctx, &appstream.DescribeImageBuildersInput{
+				Names: aws.StringSlice([]string{name}),
+			}, opts...)
+
+			if err != nil {
+				log.Printf("[ERROR] Error describing Appstream Image Builder: %s", err)
+				return nil, "", err
+			}
+
+			if len(resp.ImageBuilders) == 0 {
+				return nil, "", nil
+			}
+
+			lastState = aws.StringValue(resp.ImageBuilders[0].State)
+			log.Printf("[DEBUG] Appstream Image Builder (%s) state: %s", name, lastState)
+
+			return resp.ImageBuilders[0], lastState, nil
+		},
+	}
+
+	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+		return lastState, fmt.Errorf("error waiting for Appstream Image Builder (%s) to become %s: %w", name, target, err)
+	}
+
+	return lastState, nil
+}
+
+// otherStates returns the states other than the target.
+func otherStates(states []string, target string) []string {
+	others := make([]string, 0, len(states))
+	for _, state := range states {
+		if state != target {
+			others = append(others, state)
+		}
+	}
+
+	return others
+}
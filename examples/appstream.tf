@@ -64,5 +64,5 @@ resource "appstream_fleet" "test_fleet" {
 
 resource "appstream_stack_attachment" "eaglepro" {
   appstream_stack_id = appstream_stack.test_stack.name
-  appstream_fleet_id = appstream_fleet.test_fleet.name
+  appstream_fleet_id = appstream_fleet.test_fleet.fleet_name
 }